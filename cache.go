@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// cacheCommand returns the cache command.
+func (app *application) cacheCommand() *command {
+	var all bool
+
+	return &command{
+		name:    "cache",
+		summary: "Inspects and edits the NATS cache bucket, whose keys are item IDs.",
+		commands: []*command{
+			{
+				name:    "get",
+				args:    "<key>",
+				summary: "Prints a cached value.",
+				run: func(args []string) error {
+					if len(args) != 1 {
+						return errors.New("usage: exampleapp cache get <key>")
+					}
+
+					return app.withCache(func(ctx context.Context) error {
+						entry, err := app.cache.Get(ctx, args[0])
+						if err != nil {
+							return fmt.Errorf("cache key %q: %w", args[0], err)
+						}
+
+						_, err = os.Stdout.Write(entry.Value())
+						return err
+					})
+				},
+			},
+			{
+				name:    "put",
+				args:    "<key> [value]",
+				summary: "Caches a value, read from stdin when not given. Entries expire with the bucket TTL.",
+				run: func(args []string) error {
+					if len(args) != 1 && len(args) != 2 {
+						return errors.New("usage: exampleapp cache put <key> [value]")
+					}
+
+					var value []byte
+					if len(args) == 2 {
+						value = []byte(args[1])
+					} else {
+						var err error
+						if value, err = io.ReadAll(os.Stdin); err != nil {
+							return err
+						}
+					}
+
+					return app.withCache(func(ctx context.Context) error {
+						_, err := app.cache.Put(ctx, args[0], value)
+						return err
+					})
+				},
+			},
+			{
+				name:    "purge",
+				args:    "[key ...]",
+				summary: "Removes the given keys from the cache, or every key with -all.",
+				flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&all, "all", false, "Purge every key")
+				},
+				run: func(args []string) error {
+					if all == (len(args) > 0) {
+						return errors.New("usage: exampleapp cache purge -all | <key> ...")
+					}
+
+					return app.withCache(func(ctx context.Context) error {
+						keys := args
+
+						if all {
+							lister, err := app.cache.ListKeys(ctx)
+							if err != nil {
+								return err
+							}
+
+							for key := range lister.Keys() {
+								keys = append(keys, key)
+							}
+						}
+
+						for _, key := range keys {
+							if err := app.cache.Purge(ctx, key); err != nil {
+								return err
+							}
+						}
+
+						app.logger.Info("purged cache keys", slog.Int("purged", len(keys)))
+
+						return nil
+					})
+				},
+			},
+		},
+	}
+}
+
+// withCache connects to the cache bucket and runs fn.
+func (app *application) withCache(fn func(ctx context.Context) error) error {
+	ctx := context.Background()
+
+	shutdown, err := app.natsConnect()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	if err = app.startCache(ctx); err != nil {
+		return err
+	}
+
+	return fn(ctx)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"exampleapp/internal/secret"
+)
+
+// command is a subcommand of the binary, or a group of them. Every command answers -h and --help with its usage, and
+// accepts the config flags after its name as well as before.
+type command struct {
+	name     string
+	args     string // arguments after the command's flags, for its usage line
+	summary  string
+	flags    func(fs *flag.FlagSet) // registers the command's flags, if any
+	run      func(args []string) error
+	commands []*command // subcommands, making this a group
+	noConfig bool       // runs without a valid config, such as version
+}
+
+// commands returns the command tree, every command sharing the application and its config.
+func (app *application) commands() *command {
+	return &command{
+		name:    "exampleapp",
+		args:    "[command]",
+		summary: "Serves the example site, or runs one of the commands below. Runs serve without a command.",
+		run: func(args []string) error {
+			return app.serve()
+		},
+		commands: []*command{
+			{
+				name:    "serve",
+				summary: "Starts the embedded NATS server and serves the site over HTTP.",
+				run: func(args []string) error {
+					return app.serve()
+				},
+			},
+			app.migrateCommand(),
+			app.configCommand(),
+			app.sessionsCommand(),
+			app.cacheCommand(),
+			app.itemsCommand(),
+			{
+				name:     "version",
+				summary:  "Prints the version.",
+				noConfig: true,
+				run: func(args []string) error {
+					fmt.Printf("exampleapp %s %s\n", Version, runtime.Version())
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// execute runs the command named by args below c, printing help instead when asked for it. configErr is the error
+// loading the config, which only fails commands that need the config. Config flags given after the command are
+// loaded into app's config over those given before it.
+func (c *command) execute(app *application, args []string, configErr error) error {
+	return c.exec(app, c.name, args, configErr)
+}
+
+func (c *command) exec(app *application, path string, args []string, configErr error) error {
+	if len(c.commands) > 0 && len(args) > 0 {
+		if isHelp(args[0]) {
+			return c.help(os.Stdout, path)
+		}
+
+		i := slices.IndexFunc(c.commands, func(sub *command) bool { return sub.name == args[0] })
+		if i < 0 {
+			return fmt.Errorf("unknown command %q, see %s --help", path+" "+args[0], path)
+		}
+
+		sub := c.commands[i]
+		return sub.exec(app, path+" "+sub.name, args[1:], configErr)
+	}
+
+	if c.run == nil {
+		return fmt.Errorf("usage: %s, see %s --help", c.usage(path), path)
+	}
+
+	// The root's flags were parsed with the config, only leaves have flags of their own here
+	if len(c.commands) == 0 {
+		fs := flag.NewFlagSet(path, flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		if c.flags != nil {
+			c.flags(fs)
+		}
+
+		shared := configFlags()
+		shared.VisitAll(func(f *flag.Flag) {
+			if fs.Lookup(f.Name) == nil {
+				fs.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return c.help(os.Stdout, path)
+			}
+			return fmt.Errorf("%s: %w, see %s --help", path, err, path)
+		}
+		args = fs.Args()
+
+		var extra []string
+		fs.Visit(func(f *flag.Flag) {
+			if shared.Lookup(f.Name) != nil {
+				extra = append(extra, flagArg(f))
+			}
+		})
+
+		if len(extra) > 0 {
+			if configErr = app.reconfigure(extra); configErr != nil && !isConfigError(configErr) {
+				return configErr
+			}
+		}
+	}
+
+	if configErr != nil && !c.noConfig {
+		return configErr
+	}
+
+	return c.run(args)
+}
+
+// isHelp reports whether arg asks for help.
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help" || arg == "help"
+}
+
+// configFlags returns a flag set holding just the config flags, set apart from any config.
+func configFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	cfg := defaultConfig()
+	cfg.addFlags(fs, new(string))
+
+	return fs
+}
+
+// flagArg turns a flag set on the command line back into a single argument, secrets included as given.
+func flagArg(f *flag.Flag) string {
+	value := f.Value.String()
+	if s, ok := f.Value.(*secret.String); ok {
+		value = s.Reveal()
+	}
+
+	return "-" + f.Name + "=" + value
+}
+
+// isConfigError reports whether err lists invalid settings, rather than the config failing to load at all.
+func isConfigError(err error) bool {
+	var invalid configError
+	return errors.As(err, &invalid)
+}
+
+// usage returns the command's usage line.
+func (c *command) usage(path string) string {
+	usage := path
+	if len(c.commands) == 0 || c.run != nil {
+		usage += " [flags]"
+	}
+
+	switch {
+	case c.args != "":
+		usage += " " + c.args
+	case len(c.commands) > 0:
+		usage += " <command>"
+	}
+
+	return usage
+}
+
+// help writes the command's usage, summary, subcommands and flags to w.
+func (c *command) help(w io.Writer, path string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintf(tw, "usage: %s\n\n%s\n", c.usage(path), c.summary)
+
+	if len(c.commands) > 0 {
+		_, _ = fmt.Fprintln(tw, "\ncommands:")
+		for _, sub := range c.commands {
+			_, _ = fmt.Fprintf(tw, "  %s\t%s\n", sub.name, firstSentence(sub.summary))
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if c.flags != nil {
+		fs := flag.NewFlagSet(path, flag.ContinueOnError)
+		fs.SetOutput(w)
+		c.flags(fs)
+
+		_, _ = fmt.Fprintln(w, "\nflags:")
+		fs.PrintDefaults()
+	}
+
+	// Groups only pass the config flags on to their commands
+	if len(c.commands) == 0 || c.run != nil {
+		fs := configFlags()
+		fs.SetOutput(w)
+
+		_, _ = fmt.Fprintln(w, "\nconfig flags:")
+		fs.PrintDefaults()
+	}
+
+	return nil
+}
+
+// firstSentence returns the first sentence of a summary, for listing commands.
+func firstSentence(summary string) string {
+	sentence, _, _ := strings.Cut(summary, ". ")
+	return strings.TrimSuffix(sentence, ".")
+}
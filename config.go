@@ -1,14 +1,41 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nats-io/nats.go/jetstream"
+	"gopkg.in/yaml.v3"
+
+	"exampleapp/internal/secret"
+	"exampleapp/internal/validator"
 )
 
 type config struct {
+	log struct {
+		level string
+	}
+	admin struct {
+		token secret.String // bearer token for the /admin endpoints, which are disabled without one
+	}
 	http struct {
 		address string
 		port    int
@@ -22,10 +49,12 @@ type config struct {
 		bucketName string
 		prefix     string
 		TTL        time.Duration
+		keys       secret.String // comma separated id:base64key pairs, the first is the primary encryption key
+
+		optimisticConcurrency bool // commit sessions only over the revision read, merging concurrent changes
 	}
 	cache struct {
 		bucketName string
-		prefix     string
 		TTL        time.Duration
 	}
 	database struct {
@@ -33,150 +62,574 @@ type config struct {
 		port    int
 		name    string
 		user    string
-		pass    string
+		pass    secret.String
 		sslmode string
+
+		migrateOnStart bool
 	}
+	items struct {
+		eventSourced bool // items are stored as events in JetStream, with Postgres and the cache as projections
+	}
+
+	file    string            // config file the settings were loaded from, if any
+	sources map[string]string // where each setting was last set from, see the source constants
 }
 
-func setDefaultInt(envVar string, defaultValue int) (int, error) {
-	var (
-		err   error
-		value int
-		ok    bool
-	)
+// Where a setting's value came from, in increasing order of precedence.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceEnvFile = "env file" // read from the file named by a secret's _FILE environment variable
+	sourceFlag    = "flag"
+)
 
-	if _, ok = os.LookupEnv(envVar); !ok {
-		value = defaultValue
-	} else {
-		value, err = strconv.Atoi(os.Getenv(envVar))
-		if err != nil {
-			return 0, err
-		}
+// setting describes a config value and the places it can be set from.
+type setting struct {
+	name  string // dotted path of the setting in config files, also used in errors
+	env   string // environment variable
+	flag  string // command line flag
+	usage string
+	value any // pointer to the config field, a *string, *secret.String, *int, *bool or *time.Duration
+}
+
+// settings lists every setting, pointing into c.
+func (c *config) settings() []setting {
+	return []setting{
+		{name: "log.level", env: "APP_LOG_LEVEL", flag: "log-level", usage: "Log level: debug, info, warn or error", value: &c.log.level},
+		{name: "admin.token", env: "APP_ADMIN_TOKEN", flag: "admin-token", usage: "Bearer token for the /admin endpoints, disabled when empty", value: &c.admin.token},
+		{name: "http.address", env: "APP_LISTEN_ADDR", flag: "http-address", usage: "HTTP listen address", value: &c.http.address},
+		{name: "http.port", env: "APP_HTTP_PORT", flag: "http-port", usage: "HTTP listen port", value: &c.http.port},
+		{name: "nats.address", env: "APP_NATS_ADDR", flag: "nats-address", usage: "NATS listen address", value: &c.nats.address},
+		{name: "nats.port", env: "APP_NATS_PORT", flag: "nats-port", usage: "NATS listen port", value: &c.nats.port},
+		{name: "nats.store_dir", env: "APP_NATS_STORAGE_DIR", flag: "nats-store-dir", usage: "NATS store directory", value: &c.nats.storeDir},
+		{name: "sessions.bucket_name", env: "APP_SESSION_BUCKET_NAME", flag: "sessions-bucket-name", usage: "Session storage bucket name", value: &c.sessions.bucketName},
+		{name: "sessions.prefix", env: "APP_SESSION_PREFIX", flag: "sessions-prefix", usage: "Session storage key prefix", value: &c.sessions.prefix},
+		{name: "sessions.ttl", env: "APP_SESSION_TTL", flag: "sessions-ttl", usage: "Session storage TTL", value: &c.sessions.TTL},
+		{name: "sessions.keys", env: "APP_SESSION_KEYS", flag: "sessions-keys", usage: "Session encryption keys as comma separated id:base64key pairs, first is primary", value: &c.sessions.keys},
+		{name: "sessions.optimistic_concurrency", env: "APP_SESSION_OPTIMISTIC_CONCURRENCY", flag: "sessions-optimistic-concurrency", usage: "Merge sessions changed by concurrent requests instead of the last commit winning", value: &c.sessions.optimisticConcurrency},
+		{name: "cache.bucket_name", env: "APP_CACHE_BUCKET_NAME", flag: "cache-bucket-name", usage: "Cache storage bucket name", value: &c.cache.bucketName},
+		{name: "cache.ttl", env: "APP_CACHE_TTL", flag: "cache-ttl", usage: "Cache storage TTL", value: &c.cache.TTL},
+		{name: "database.host", env: "APP_DATABASE_HOST", flag: "database-host", usage: "Database host", value: &c.database.host},
+		{name: "database.port", env: "APP_DATABASE_PORT", flag: "database-port", usage: "Database port", value: &c.database.port},
+		{name: "database.name", env: "APP_DATABASE_NAME", flag: "database-name", usage: "Database name", value: &c.database.name},
+		{name: "database.user", env: "APP_DATABASE_USERNAME", flag: "database-username", usage: "Database user", value: &c.database.user},
+		{name: "database.password", env: "APP_DATABASE_PASSWORD", flag: "database-password", usage: "Database password", value: &c.database.pass},
+		{name: "database.sslmode", env: "APP_DATABASE_SSLMODE", flag: "database-sslmode", usage: "Database sslmode", value: &c.database.sslmode},
+		{name: "database.migrate_on_start", env: "APP_DATABASE_MIGRATE_ON_START", flag: "migrate-on-start", usage: "Apply pending database migrations before serving", value: &c.database.migrateOnStart},
+		{name: "items.event_sourced", env: "APP_ITEMS_EVENT_SOURCED", flag: "items-event-sourced", usage: "Store items as JetStream events projected into Postgres and the cache", value: &c.items.eventSourced},
 	}
+}
+
+var (
+	// bucketNameRx matches names NATS accepts for KV buckets.
+	bucketNameRx = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// keyPrefixRx matches the characters NATS accepts in KV keys.
+	keyPrefixRx = regexp.MustCompile(`^[-/_=.a-zA-Z0-9]*$`)
+
+	// hostnameRx matches DNS hostnames.
+	hostnameRx = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
 
-	return value, nil
+	sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+	logLevels = []string{"debug", "info", "warn", "error"}
+)
+
+// configError lists every problem found while loading the config, keyed by setting.
+type configError map[string]string
+
+func (e configError) Error() string {
+	settings := slices.Sorted(maps.Keys(e))
+
+	problems := make([]string, 0, len(settings))
+	for _, setting := range settings {
+		problems = append(problems, setting+": "+e[setting])
+	}
+
+	return "invalid config: " + strings.Join(problems, "; ")
 }
 
-func setDefaultDuration(envVar string, defaultValue time.Duration) (time.Duration, error) {
-	var (
-		err   error
-		value time.Duration
-		ok    bool
-	)
+// loadConfig builds the config from defaults, an optional config file, APP_* environment variables and command line
+// flags, each overriding the last, then validates it. Every problem found is reported at once in a single error. The
+// arguments remaining after the flags are returned for subcommand dispatch.
+func loadConfig(args []string) (config, []string, error) {
+	// The config file is named by a flag or env var but sits below both, so find it with a first pass over the flags
+	var file string
 
-	if _, ok = os.LookupEnv(envVar); !ok {
-		return defaultValue, nil
-	} else {
-		value, err = time.ParseDuration(os.Getenv(envVar))
-		if err != nil {
-			return defaultValue, err
+	scratch := defaultConfig()
+	if err := scratch.flagSet(&file).Parse(args); err != nil {
+		return scratch, nil, err
+	}
+	if file == "" {
+		file = os.Getenv("APP_CONFIG_FILE")
+	}
+
+	cfg := defaultConfig()
+	v := validator.New()
+
+	if file != "" {
+		if err := cfg.loadFile(v, file); err != nil {
+			return cfg, nil, err
 		}
 	}
 
-	return value, nil
+	cfg.loadEnv(v)
+
+	fs := cfg.flagSet(&file)
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+	cfg.noteFlags(fs)
+
+	cfg.validate(v)
+
+	if !v.Valid() {
+		return cfg, fs.Args(), configError(v.Errors)
+	}
+
+	return cfg, fs.Args(), nil
 }
 
-func (app *application) setDefaults() error {
-	var (
-		err error
-		ok  bool
-	)
+func defaultConfig() config {
+	var cfg config
+
+	cfg.log.level = "info"
+
+	cfg.http.address = "0.0.0.0"
+	cfg.http.port = 8080
+
+	cfg.nats.address = "0.0.0.0"
+	cfg.nats.port = 4222
+	cfg.nats.storeDir = "./data"
+
+	cfg.sessions.bucketName = "sessions"
+	cfg.sessions.prefix = "scs"
+	cfg.sessions.TTL = 24 * time.Hour
 
-	// Check env vars and set defaults
-	if app.config.http.address, ok = os.LookupEnv("APP_LISTEN_ADDR"); !ok {
-		app.config.http.address = "0.0.0.0"
+	cfg.cache.bucketName = "cache"
+	cfg.cache.TTL = 24 * time.Hour
+
+	cfg.database.host = "localhost"
+	cfg.database.port = 5432
+	cfg.database.name = "exampleapp"
+	cfg.database.user = "exampleapp"
+	cfg.database.pass = "exampleapp"
+	cfg.database.sslmode = "disable"
+
+	cfg.sources = make(map[string]string)
+	for _, s := range cfg.settings() {
+		cfg.sources[s.name] = sourceDefault
 	}
 
-	if app.config.http.port, err = setDefaultInt("APP_HTTP_PORT", 8080); err != nil {
-		app.logger.Error("unable to parse APP_HTTP_PORT", slog.String("error", err.Error()))
-		return err
+	return cfg
+}
+
+// loadFile overrides the config with the settings in a JSON, TOML or YAML file, picked by its extension, recording
+// unknown settings and values of the wrong type in v. Files that can't be read or parsed fail outright.
+func (c *config) loadFile(v *validator.Validator, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
 	}
 
-	if app.config.nats.address, ok = os.LookupEnv("APP_NATS_ADDR"); !ok {
-		app.config.nats.address = "0.0.0.0"
+	var doc map[string]any
+
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		err = json.Unmarshal(b, &doc)
+	case ".toml":
+		err = toml.Unmarshal(b, &doc)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &doc)
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q, use .json, .toml or .yaml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
 	}
 
-	if app.config.nats.port, err = setDefaultInt("APP_NATS_PORT", 4222); err != nil {
-		app.logger.Error("unable to parse APP_NATS_PORT", slog.String("error", err.Error()))
-		return err
+	values := make(map[string]any)
+	flatten(values, "", doc)
+
+	for _, s := range c.settings() {
+		raw, ok := values[s.name]
+		if !ok {
+			continue
+		}
+		delete(values, s.name)
+
+		if err = setFileValue(s.value, raw); err != nil {
+			v.AddError(s.name, "config file value "+err.Error())
+			continue
+		}
+		c.sources[s.name] = sourceFile
 	}
 
-	if app.config.nats.storeDir, ok = os.LookupEnv("APP_NATS_STORAGE_DIR"); !ok {
-		app.config.nats.storeDir = "./data"
+	for name := range values {
+		v.AddError(name, "unknown setting in config file")
 	}
 
-	if app.config.sessions.bucketName, ok = os.LookupEnv("APP_SESSION_BUCKET_NAME"); !ok {
-		app.config.sessions.bucketName = "sessions"
+	c.file = path
+
+	return nil
+}
+
+// flatten collects the leaves of a nested config file document into values, keyed by their dotted path.
+func flatten(values map[string]any, prefix string, doc map[string]any) {
+	for key, value := range doc {
+		if table, ok := value.(map[string]any); ok {
+			flatten(values, prefix+key+".", table)
+			continue
+		}
+		values[prefix+key] = value
 	}
+}
 
-	if app.config.sessions.prefix, ok = os.LookupEnv("APP_SESSION_PREFIX"); !ok {
-		app.config.sessions.prefix = "scs"
+// setFileValue sets a config field from a decoded config file value. Durations are written as strings such as "24h".
+func setFileValue(value any, raw any) error {
+	switch p := value.(type) {
+	case *string:
+		s, ok := raw.(string)
+		if !ok {
+			return errors.New("must be a string")
+		}
+		*p = s
+	case *secret.String:
+		s, ok := raw.(string)
+		if !ok {
+			return errors.New("must be a string")
+		}
+		*p = secret.String(s)
+	case *int:
+		switch n := raw.(type) {
+		case int:
+			*p = n
+		case int64:
+			*p = int(n)
+		case float64:
+			if n != math.Trunc(n) {
+				return errors.New("must be an integer")
+			}
+			*p = int(n)
+		default:
+			return errors.New("must be an integer")
+		}
+	case *bool:
+		b, ok := raw.(bool)
+		if !ok {
+			return errors.New("must be true or false")
+		}
+		*p = b
+	case *time.Duration:
+		s, ok := raw.(string)
+		if !ok {
+			return errors.New("must be a duration string such as \"30m\" or \"24h\"")
+		}
+		return setValue(p, s)
 	}
 
-	if app.config.sessions.TTL, err = setDefaultDuration("APP_SESSION_TTL", 24*time.Hour); err != nil {
-		app.logger.Error("unable to parse APP_SESSION_TTL", slog.String("error", err.Error()))
-		return err
+	return nil
+}
+
+// setValue parses s into a config field.
+func setValue(value any, s string) error {
+	switch p := value.(type) {
+	case *string:
+		*p = s
+	case *secret.String:
+		*p = secret.String(s)
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		*p = b
+	case *time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("must be a duration such as 30m or 24h")
+		}
+		*p = d
+	}
+
+	return nil
+}
+
+// loadEnv overrides the config with any APP_* environment variables set, recording values that don't parse in v.
+// Secrets may instead be read from the file named by the variable with a _FILE suffix, such as
+// APP_DATABASE_PASSWORD_FILE, keeping them out of the environment.
+func (c *config) loadEnv(v *validator.Validator) {
+	for _, s := range c.settings() {
+		value, ok := os.LookupEnv(s.env)
+		source := sourceEnv
+
+		if _, isSecret := s.value.(*secret.String); isSecret {
+			if path, fromFile := os.LookupEnv(s.env + "_FILE"); fromFile {
+				if ok {
+					v.AddError(s.name, "only one of "+s.env+" and "+s.env+"_FILE may be set")
+					continue
+				}
+
+				b, err := os.ReadFile(path)
+				if err != nil {
+					v.AddError(s.name, s.env+"_FILE: "+err.Error())
+					continue
+				}
+
+				// Editors and secret managers usually end files with a newline
+				value, ok, source = strings.TrimRight(string(b), "\r\n"), true, sourceEnvFile
+			}
+		}
+
+		if !ok {
+			continue
+		}
+
+		if err := setValue(s.value, value); err != nil {
+			v.AddError(s.name, s.env+" "+err.Error())
+			continue
+		}
+		c.sources[s.name] = source
+	}
+}
+
+// secrets returns the secret values the operator has set, for redacting from logs. Defaults are public already.
+func (c *config) secrets() []string {
+	var secrets []string
+
+	for _, s := range c.settings() {
+		p, ok := s.value.(*secret.String)
+		if !ok || c.sources[s.name] == sourceDefault {
+			continue
+		}
+
+		// Also as escaped in connection strings, including the user info of databaseDSN
+		value := p.Reveal()
+		userinfo := strings.TrimPrefix(url.UserPassword("", value).String(), ":")
+		secrets = append(secrets, value, url.PathEscape(value), url.QueryEscape(value), userinfo)
 	}
 
-	if app.config.cache.bucketName, ok = os.LookupEnv("APP_CACHE_BUCKET_NAME"); !ok {
-		app.config.cache.bucketName = "cache"
+	// Each session key on its own too
+	if c.sources["sessions.keys"] != sourceDefault {
+		for pair := range strings.SplitSeq(c.sessions.keys.Reveal(), ",") {
+			if _, key, ok := strings.Cut(strings.TrimSpace(pair), ":"); ok {
+				secrets = append(secrets, key)
+			}
+		}
 	}
 
-	if app.config.cache.prefix, ok = os.LookupEnv("APP_CACHE_PREFIX"); !ok {
-		app.config.cache.prefix = ""
+	return secrets
+}
+
+// flagSet returns the command line flags, defaulting to and overriding the current config. The -config flag is
+// stored in file. Usage and errors aren't printed, the caller reports them, see application.commands.
+func (c *config) flagSet(file *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("exampleapp", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	c.addFlags(fs, file)
+
+	return fs
+}
+
+// addFlags registers the config's command line flags in fs, see flagSet.
+func (c *config) addFlags(fs *flag.FlagSet, file *string) {
+	fs.StringVar(file, "config", *file, "Config file (.json, .toml or .yaml), also set by APP_CONFIG_FILE")
+
+	for _, s := range c.settings() {
+		switch p := s.value.(type) {
+		case *string:
+			fs.StringVar(p, s.flag, *p, s.usage)
+		case *secret.String:
+			fs.Var(p, s.flag, s.usage)
+		case *int:
+			fs.IntVar(p, s.flag, *p, s.usage)
+		case *bool:
+			fs.BoolVar(p, s.flag, *p, s.usage)
+		case *time.Duration:
+			fs.DurationVar(p, s.flag, *p, s.usage)
+		}
+	}
+}
+
+// noteFlags records the settings given on the command line as coming from flags.
+func (c *config) noteFlags(fs *flag.FlagSet) {
+	names := make(map[string]string)
+	for _, s := range c.settings() {
+		names[s.flag] = s.name
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if name, ok := names[f.Name]; ok {
+			c.sources[name] = sourceFlag
+		}
+	})
+}
+
+// validate records every invalid setting in v.
+func (c *config) validate(v *validator.Validator) {
+	v.Check(validator.PermittedValue(c.log.level, logLevels...), "log.level", "must be one of "+strings.Join(logLevels, ", "))
+
+	checkAddress(v, "http.address", c.http.address)
+	checkPort(v, "http.port", c.http.port)
+
+	checkAddress(v, "nats.address", c.nats.address)
+	checkPort(v, "nats.port", c.nats.port)
+	v.Check(c.nats.storeDir != "", "nats.store_dir", "must be provided")
+
+	checkBucketName(v, "sessions.bucket_name", c.sessions.bucketName)
+	checkKeyPrefix(v, "sessions.prefix", c.sessions.prefix)
+	checkTTL(v, "sessions.ttl", c.sessions.TTL)
+	if c.sessions.keys != "" {
+		if _, err := parseKeyring(c.sessions.keys.Reveal()); err != nil {
+			v.AddError("sessions.keys", err.Error())
+		}
 	}
 
-	if app.config.cache.TTL, err = setDefaultDuration("APP_CACHE_TTL", 24*time.Hour); err != nil {
-		app.logger.Error("unable to parse APP_CACHE_TTL", slog.String("error", err.Error()))
-		return err
+	checkBucketName(v, "cache.bucket_name", c.cache.bucketName)
+	checkTTL(v, "cache.ttl", c.cache.TTL)
+
+	checkAddress(v, "database.host", c.database.host)
+	checkPort(v, "database.port", c.database.port)
+	v.Check(c.database.name != "", "database.name", "must be provided")
+	v.Check(c.database.user != "", "database.user", "must be provided")
+	v.Check(validator.PermittedValue(c.database.sslmode, sslModes...), "database.sslmode", "must be one of "+strings.Join(sslModes, ", "))
+}
+
+func checkAddress(v *validator.Validator, setting, address string) {
+	v.Check(address != "", setting, "must be provided")
+	v.Check(net.ParseIP(address) != nil || validator.Matches(address, hostnameRx), setting, "must be an IP address or hostname")
+}
+
+func checkPort(v *validator.Validator, setting string, port int) {
+	v.Check(port >= 1 && port <= 65535, setting, "must be a port between 1 and 65535")
+}
+
+func checkBucketName(v *validator.Validator, setting, name string) {
+	v.Check(name != "", setting, "must be provided")
+	v.Check(validator.Matches(name, bucketNameRx), setting, "must only contain letters, numbers, - and _")
+}
+
+func checkKeyPrefix(v *validator.Validator, setting, prefix string) {
+	v.Check(validator.Matches(prefix, keyPrefixRx), setting, "must only contain letters, numbers and - / _ = .")
+	v.Check(!strings.HasPrefix(prefix, "."), setting, "must not start with .")
+}
+
+// checkTTL requires at least one second, the smallest TTL JetStream applies to keys.
+func checkTTL(v *validator.Validator, setting string, ttl time.Duration) {
+	v.Check(ttl >= time.Second, setting, "must be at least 1s")
+}
+
+// logLevel returns the configured log level.
+func (c *config) logLevel() slog.Level {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(c.log.level)) // validated
+	return level
+}
+
+// sessionBuckets returns the config of the session bucket and the bucket indexing sessions by user.
+func (c *config) sessionBuckets() (sessions, users jetstream.KeyValueConfig) {
+	sessions = jetstream.KeyValueConfig{
+		Bucket:         c.sessions.bucketName,
+		Compression:    true,
+		TTL:            c.sessions.TTL, // upper bound, each session expires per its scs expiry
+		LimitMarkerTTL: time.Minute,    // enables per-message TTLs
 	}
 
-	if app.config.database.host, ok = os.LookupEnv("APP_DATABASE_HOST"); !ok {
-		app.config.database.host = "localhost"
+	users = jetstream.KeyValueConfig{
+		Bucket: c.sessions.bucketName + "-users",
+		TTL:    c.sessions.TTL,
 	}
 
-	if app.config.database.port, err = setDefaultInt("APP_DATABASE_PORT", 5432); err != nil {
-		app.logger.Error("unable to parse APP_DATABASE_PORT", slog.String("error", err.Error()))
-		return err
+	return sessions, users
+}
+
+// cacheBucket returns the config of the cache bucket.
+func (c *config) cacheBucket() jetstream.KeyValueConfig {
+	return jetstream.KeyValueConfig{
+		Bucket:         c.cache.bucketName,
+		Compression:    true,
+		TTL:            c.cache.TTL,
+		LimitMarkerTTL: time.Minute, // enables per-message TTLs for negative cache entries
 	}
+}
 
-	if app.config.database.name, ok = os.LookupEnv("APP_DATABASE_NAME"); !ok {
-		app.config.database.name = "exampleapp"
+// databaseDSN builds a Postgres connection URI from the database config.
+func (c *config) databaseDSN() string {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.database.user, c.database.pass.Reveal()),
+		Host:     net.JoinHostPort(c.database.host, strconv.Itoa(c.database.port)),
+		Path:     "/" + c.database.name,
+		RawQuery: url.Values{"sslmode": {c.database.sslmode}}.Encode(),
 	}
 
-	if app.config.database.name, ok = os.LookupEnv("APP_DATABASE_USERNAME"); !ok {
-		app.config.database.name = "exampleapp"
+	return dsn.String()
+}
+
+// configCommand returns the config command.
+func (app *application) configCommand() *command {
+	return &command{
+		name:    "config",
+		summary: "Inspects the config.",
+		commands: []*command{
+			{
+				name:    "print",
+				summary: "Prints the effective config and where each setting came from, with secrets redacted.",
+				run: func(args []string) error {
+					return app.config.print(os.Stdout)
+				},
+			},
+		},
 	}
+}
+
+// print writes the effective config with the source of each setting, secrets redacted.
+func (c *config) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
 
-	if app.config.database.name, ok = os.LookupEnv("APP_DATABASE_PASSWORD"); !ok {
-		app.config.database.name = "exampleapp"
+	if c.file != "" {
+		_, _ = fmt.Fprintf(tw, "# config file: %s\n", c.file)
 	}
 
-	if app.config.database.name, ok = os.LookupEnv("APP_DATABASE_SSLMODE"); !ok {
-		app.config.database.name = "disable"
+	_, _ = fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range c.settings() {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", s.name, s.display(), c.sources[s.name])
 	}
 
-	return nil
+	return tw.Flush()
+}
+
+// get returns the setting's value.
+func (s setting) get() any {
+	return reflect.ValueOf(s.value).Elem().Interface()
+}
+
+// set sets the setting to the value of another config's same setting.
+func (s setting) set(from setting) {
+	reflect.ValueOf(s.value).Elem().Set(reflect.ValueOf(from.value).Elem())
 }
 
-func (app *application) parseFlags() {
-	flag.StringVar(&app.config.http.address, "http-address", app.config.http.address, "HTTP listen address")
-	flag.IntVar(&app.config.http.port, "http-port", app.config.http.port, "HTTP listen port")
-	flag.StringVar(&app.config.nats.address, "nats-address", app.config.nats.address, "NATS listen address")
-	flag.IntVar(&app.config.nats.port, "nats-port", app.config.nats.port, "NATS listen port")
-	flag.StringVar(&app.config.nats.storeDir, "nats-store-dir", app.config.nats.storeDir, "NATS store directory")
-	flag.StringVar(&app.config.sessions.bucketName, "sessions-bucket-name", app.config.sessions.bucketName, "Session storage bucket name")
-	flag.StringVar(&app.config.sessions.prefix, "sessions-prefix", app.config.sessions.prefix, "Session storage key prefix")
-	flag.DurationVar(&app.config.sessions.TTL, "sessions-ttl", app.config.sessions.TTL, "Session storage TTL")
-	flag.StringVar(&app.config.cache.bucketName, "cache-bucket-name", app.config.cache.bucketName, "Cache storage bucket name")
-	flag.StringVar(&app.config.cache.prefix, "cache-prefix", app.config.cache.prefix, "Cache storage key prefix")
-	flag.DurationVar(&app.config.cache.TTL, "cache-ttl", app.config.cache.TTL, "Cache storage TTL")
-	flag.StringVar(&app.config.database.host, "database-host", app.config.database.host, "Database host")
-	flag.IntVar(&app.config.database.port, "database-port", app.config.database.port, "Database port")
-	flag.StringVar(&app.config.database.name, "database-name", app.config.database.name, "Database name")
-	flag.StringVar(&app.config.database.user, "database-username", app.config.database.user, "Database user")
-	flag.StringVar(&app.config.database.pass, "database-password", app.config.database.pass, "Database password")
-	flag.StringVar(&app.config.database.sslmode, "database-sslmode", app.config.database.sslmode, "Database sslmode")
-	flag.Parse()
+// display formats the setting's value for printing, secrets print redacted.
+func (s setting) display() string {
+	switch p := s.value.(type) {
+	case *string:
+		return strconv.Quote(*p)
+	case *secret.String:
+		return strconv.Quote(p.String())
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *time.Duration:
+		return p.String()
+	}
 
+	return ""
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"exampleapp/internal/secret"
+	"exampleapp/internal/validator"
+)
+
+func TestLoadEnvSecrets(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		source  string
+		problem string // expected in the database.password error, empty when valid
+	}{
+		{
+			name:   "env",
+			env:    map[string]string{"APP_DATABASE_PASSWORD": "from env"},
+			want:   "from env",
+			source: sourceEnv,
+		},
+		{
+			name:   "file",
+			env:    map[string]string{"APP_DATABASE_PASSWORD_FILE": write("password", "from file\n")},
+			want:   "from file",
+			source: sourceEnvFile,
+		},
+		{
+			name:   "file with crlf",
+			env:    map[string]string{"APP_DATABASE_PASSWORD_FILE": write("crlf", "from file\r\n")},
+			want:   "from file",
+			source: sourceEnvFile,
+		},
+		{
+			name: "both",
+			env: map[string]string{
+				"APP_DATABASE_PASSWORD":      "from env",
+				"APP_DATABASE_PASSWORD_FILE": write("both", "from file\n"),
+			},
+			problem: "only one of APP_DATABASE_PASSWORD and APP_DATABASE_PASSWORD_FILE may be set",
+		},
+		{
+			name:    "missing file",
+			env:     map[string]string{"APP_DATABASE_PASSWORD_FILE": filepath.Join(dir, "missing")},
+			problem: "APP_DATABASE_PASSWORD_FILE: ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg := defaultConfig()
+			v := validator.New()
+			cfg.loadEnv(v)
+
+			if tt.problem != "" {
+				if problem := v.Errors["database.password"]; !strings.Contains(problem, tt.problem) {
+					t.Fatalf("database.password error %q, want %q", problem, tt.problem)
+				}
+				if cfg.sources["database.password"] != sourceDefault {
+					t.Fatalf("database.password set from %s despite the error", cfg.sources["database.password"])
+				}
+				return
+			}
+
+			if !v.Valid() {
+				t.Fatalf("loadEnv: %v", v.Errors)
+			}
+			if got := cfg.database.pass.Reveal(); got != tt.want {
+				t.Fatalf("database.password = %q, want %q", got, tt.want)
+			}
+			if got := cfg.sources["database.password"]; got != tt.source {
+				t.Fatalf("database.password source = %q, want %q", got, tt.source)
+			}
+		})
+	}
+}
+
+func TestSecretsRedactDSN(t *testing.T) {
+	t.Setenv("APP_DATABASE_PASSWORD", "p@ss word/1")
+
+	cfg := defaultConfig()
+	v := validator.New()
+	cfg.loadEnv(v)
+	if !v.Valid() {
+		t.Fatalf("loadEnv: %v", v.Errors)
+	}
+
+	var buf bytes.Buffer
+	r := secret.NewRedactor(cfg.secrets()...)
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ReplaceAttr: r.ReplaceAttr}))
+
+	logger.Error("unable to connect", slog.String("dsn", cfg.databaseDSN()))
+
+	if out := buf.String(); strings.Contains(out, "p@ss") || strings.Contains(out, "p%40ss") || !strings.Contains(out, "[redacted]") {
+		t.Fatalf("logged %s", out)
+	}
+
+	// Default secrets are public already, so aren't redacted
+	defaults := defaultConfig()
+	if secrets := defaults.secrets(); len(secrets) != 0 {
+		t.Fatalf("default secrets = %q, want none", secrets)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	for _, ext := range []string{"json", "toml", "yaml"} {
+		t.Run(ext, func(t *testing.T) {
+			t.Setenv("APP_HTTP_PORT", "9091")
+			t.Setenv("APP_LOG_LEVEL", "warn")
+
+			cfg, args, err := loadConfig([]string{
+				"-config", filepath.Join("testdata", "config."+ext),
+				"-log-level", "error",
+				"serve",
+			})
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+
+			if len(args) != 1 || args[0] != "serve" {
+				t.Fatalf("remaining args = %q, want [serve]", args)
+			}
+
+			tests := []struct {
+				setting string
+				got     any
+				want    any
+				source  string
+			}{
+				{"nats.port", cfg.nats.port, 4222, sourceDefault},
+				{"database.host", cfg.database.host, "db.internal", sourceFile},
+				{"sessions.ttl", cfg.sessions.TTL, 12 * time.Hour, sourceFile},
+				{"sessions.optimistic_concurrency", cfg.sessions.optimisticConcurrency, true, sourceFile},
+				{"http.port", cfg.http.port, 9091, sourceEnv},     // env over file
+				{"log.level", cfg.log.level, "error", sourceFlag}, // flag over env and file
+			}
+
+			for _, tt := range tests {
+				if tt.got != tt.want {
+					t.Errorf("%s = %v, want %v", tt.setting, tt.got, tt.want)
+				}
+				if source := cfg.sources[tt.setting]; source != tt.source {
+					t.Errorf("%s source = %q, want %q", tt.setting, source, tt.source)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, ext := range []string{"json", "toml", "yaml"} {
+		t.Run(ext, func(t *testing.T) {
+			t.Setenv("APP_NATS_PORT", "many")
+
+			_, _, err := loadConfig([]string{"-config", filepath.Join("testdata", "invalid."+ext), "-log-level", "loud"})
+
+			var cfgErr configError
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("loadConfig: got %v, want a configError", err)
+			}
+
+			// Every problem, from the file, env and flags, in the one error
+			want := map[string]string{
+				"http.port":    "config file value must be an integer",
+				"http.prot":    "unknown setting in config file",
+				"sessions.ttl": "config file value must be a duration string",
+				"nats.port":    "APP_NATS_PORT must be an integer",
+				"log.level":    "",
+			}
+
+			if len(cfgErr) != len(want) {
+				t.Errorf("got problems with %v, want %v", slices.Sorted(maps.Keys(cfgErr)), slices.Sorted(maps.Keys(want)))
+			}
+			for setting, problem := range want {
+				got, ok := cfgErr[setting]
+				if !ok || !strings.HasPrefix(got, problem) {
+					t.Errorf("%s: got %q, want %q", setting, got, problem)
+				}
+			}
+		})
+	}
+
+	// Files that can't be read or parsed fail outright
+	for _, path := range []string{filepath.Join("testdata", "missing.json"), filepath.Join("testdata", "config.ini")} {
+		var cfgErr configError
+		if _, _, err := loadConfig([]string{"-config", path}); err == nil || errors.As(err, &cfgErr) {
+			t.Errorf("loadConfig(%s): got %v, want a file error", path, err)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+// Package db embeds the SQL migrations so the binary can apply them without the migrate CLI.
+package db
+
+import "embed"
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS items_created_at_id_idx;
+ALTER TABLE items DROP COLUMN IF EXISTS created_at;
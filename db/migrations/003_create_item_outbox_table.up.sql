@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS item_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    item_id uuid NOT NULL,
+    event_type VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS item_outbox_unpublished_idx ON item_outbox (id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS item_outbox_published_idx ON item_outbox (published_at) WHERE published_at IS NOT NULL;
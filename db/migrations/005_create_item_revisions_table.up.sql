@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS item_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    item_id uuid NOT NULL,
+    action VARCHAR NOT NULL,
+    actor VARCHAR NOT NULL DEFAULT '',
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    before JSONB,
+    after JSONB
+);
+CREATE INDEX IF NOT EXISTS item_revisions_item_id_idx ON item_revisions (item_id, id);
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -26,3 +27,9 @@ func Root(page string) http.HandlerFunc {
 		}
 	}
 }
+
+// jsString quotes s as a JavaScript string literal that is also safe inside a <script> element.
+func jsString(s string) string {
+	b, _ := json.Marshal(s) // escapes <, > and & too
+	return string(b)
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/starfederation/datastar-go/datastar"
+
+	"exampleapp/internal/store"
+	"exampleapp/internal/views"
+)
+
+func Item(items store.Items) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Full page reload
+		if r.Header.Get("Datastar-Request") != "true" {
+			Root(r.URL.Path)(w, r)
+			return
+		}
+
+		item, err := items.Get(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Otherwise we have a datastar request; upgrade the connection to SSE and Patch elements and update navigation
+		sse := datastar.NewSSE(w, r)
+
+		if err = sse.PatchElementGostar(views.ItemPage(item)); err != nil {
+			log.Printf("Unable to patch item page, error: %v", err)
+		}
+
+		if err = sse.ExecuteScript(
+			fmt.Sprintf("history.pushState({}, '', %s);", jsString(r.URL.Path)),
+		); err != nil {
+			log.Printf("Unable to send SSE, error: %v", err)
+		}
+	}
+}
+
+func ItemHistory(items store.Items) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Full page reload
+		if r.Header.Get("Datastar-Request") != "true" {
+			Root(r.URL.Path)(w, r)
+			return
+		}
+
+		id := chi.URLParam(r, "id")
+
+		revisions, err := items.History(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Otherwise we have a datastar request; upgrade the connection to SSE and Patch elements and update navigation
+		sse := datastar.NewSSE(w, r)
+
+		if err = sse.PatchElementGostar(views.ItemHistoryPage(id, revisions)); err != nil {
+			log.Printf("Unable to patch item history page, error: %v", err)
+		}
+
+		if err = sse.ExecuteScript(
+			fmt.Sprintf("history.pushState({}, '', %s);", jsString(r.URL.Path)),
+		); err != nil {
+			log.Printf("Unable to send SSE, error: %v", err)
+		}
+	}
+}
+
+func Items(items store.Items) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Full page reload
+		if r.Header.Get("Datastar-Request") != "true" {
+			Root(views.ItemsURL(r.URL.Query().Get("q"), r.URL.Query().Get("cursor")))(w, r)
+			return
+		}
+
+		namePrefix := r.URL.Query().Get("q")
+		cursor := r.URL.Query().Get("cursor")
+
+		page, next, err := items.List(r.Context(), store.ListParams{
+			NamePrefix: namePrefix,
+			Cursor:     cursor,
+		})
+		if err != nil {
+			if errors.Is(err, store.ErrInvalidCursor) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		total, err := items.Count(r.Context(), namePrefix)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Otherwise we have a datastar request; upgrade the connection to SSE and Patch elements and update navigation
+		sse := datastar.NewSSE(w, r)
+
+		if err = sse.PatchElementGostar(views.ItemsPage(page, total, namePrefix, next)); err != nil {
+			log.Printf("Unable to patch items page, error: %v", err)
+		}
+
+		if err = sse.ExecuteScript(
+			fmt.Sprintf("history.pushState({}, '', %s);", jsString(views.ItemsURL(namePrefix, cursor))),
+		); err != nil {
+			log.Printf("Unable to send SSE, error: %v", err)
+		}
+	}
+}
+
+func UpdateItem(items store.Items) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		var signals struct {
+			Name    string `json:"name"`
+			Version int    `json:"version"`
+		}
+
+		if err := datastar.ReadSignals(r, &signals); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		item := &store.Item{Name: signals.Name, Version: signals.Version}
+
+		err := items.Update(r.Context(), id, item)
+
+		// Show each problem under its field
+		var verr *store.ValidationError
+		if errors.As(err, &verr) {
+			sse := datastar.NewSSE(w, r)
+
+			for field, message := range verr.Fields {
+				if err = sse.PatchElementGostar(views.ItemFieldError(field, message)); err != nil {
+					log.Printf("Unable to patch item field error, error: %v", err)
+				}
+			}
+			return
+		}
+
+		if err != nil && !errors.Is(err, store.ErrConflict) {
+			if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Someone else changed the item since it was loaded, tell the user rather than overwriting their change
+		if err != nil {
+			current, err := items.Get(r.Context(), id)
+			if err != nil {
+				if errors.Is(err, store.ErrNotFound) {
+					http.NotFound(w, r)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			sse := datastar.NewSSE(w, r)
+
+			if err = sse.PatchElementGostar(views.ItemConflict(current)); err != nil {
+				log.Printf("Unable to patch item conflict, error: %v", err)
+			}
+			return
+		}
+
+		sse := datastar.NewSSE(w, r)
+
+		if err = sse.PatchElementGostar(views.ItemPage(item)); err != nil {
+			log.Printf("Unable to patch item page, error: %v", err)
+		}
+	}
+}
+
+func DeleteItem(items store.Items) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		if err := items.Delete(r.Context(), id); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sse := datastar.NewSSE(w, r)
+
+		if err := sse.RemoveElementByID("item-" + id); err != nil {
+			log.Printf("Unable to remove item, error: %v", err)
+		}
+	}
+}
@@ -26,7 +26,7 @@ func LandingPage() http.HandlerFunc {
 		}
 
 		if err := sse.ExecuteScript(
-			fmt.Sprintf("history.pushState({}, '', '%s');", r.URL.Path),
+			fmt.Sprintf("history.pushState({}, '', %s);", jsString(r.URL.Path)),
 		); err != nil {
 			log.Printf("Unable to send SSE, error: %v", err)
 		}
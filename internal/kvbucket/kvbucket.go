@@ -0,0 +1,135 @@
+package kvbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var (
+	ErrDestructiveChange = errors.New("destructive bucket config change")
+)
+
+// Change describes a single setting that differs between the live bucket and the desired config.
+type Change struct {
+	Field string
+	From  any
+	To    any
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Field, c.From, c.To)
+}
+
+// Ensure binds to the bucket named in cfg, creating it if it doesn't exist.
+// If the live bucket has drifted from cfg, safe changes (TTL, history, replicas, compression, limits, description,
+// enabling per-message TTLs) are applied in place and logged. Changes that can't be applied without recreating the
+// bucket are refused with ErrDestructiveChange and nothing is updated.
+func Ensure(ctx context.Context, js jetstream.JetStream, cfg jetstream.KeyValueConfig, logger *slog.Logger) (jetstream.KeyValue, error) {
+	kv, err := js.KeyValue(ctx, cfg.Bucket)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrBucketNotFound) {
+			logger.Info("creating KV bucket", slog.String("bucket", cfg.Bucket))
+			return js.CreateKeyValue(ctx, cfg)
+		}
+		return nil, err
+	}
+
+	status, err := kv.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketStatus, ok := status.(*jetstream.KeyValueBucketStatus)
+	if !ok {
+		return nil, fmt.Errorf("unexpected status type %T for bucket %s", status, cfg.Bucket)
+	}
+
+	safe, destructive := Diff(bucketStatus.StreamInfo().Config, cfg)
+	if len(destructive) > 0 {
+		return nil, fmt.Errorf("%w: bucket %s: %v", ErrDestructiveChange, cfg.Bucket, destructive)
+	}
+
+	if len(safe) == 0 {
+		return kv, nil
+	}
+
+	for _, change := range safe {
+		logger.Info(
+			"updating KV bucket config",
+			slog.String("bucket", cfg.Bucket),
+			slog.String("field", change.Field),
+			slog.Any("from", change.From),
+			slog.Any("to", change.To),
+		)
+	}
+
+	return js.UpdateKeyValue(ctx, cfg)
+}
+
+// Diff compares the stream config backing a live bucket with the desired bucket config, splitting the differences
+// into changes that can be applied with an update and those that can't.
+func Diff(live jetstream.StreamConfig, cfg jetstream.KeyValueConfig) (safe, destructive []Change) {
+	history := int64(1)
+	if cfg.History > 0 {
+		history = int64(cfg.History)
+	}
+
+	replicas := cfg.Replicas
+	if replicas == 0 {
+		replicas = 1
+	}
+
+	maxBytes := cfg.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = -1
+	}
+
+	maxValueSize := cfg.MaxValueSize
+	if maxValueSize == 0 {
+		maxValueSize = -1
+	}
+
+	liveCompression := live.Compression != jetstream.NoCompression
+
+	if live.MaxAge != cfg.TTL {
+		safe = append(safe, Change{"ttl", live.MaxAge, cfg.TTL})
+	}
+	if live.MaxMsgsPerSubject != history {
+		safe = append(safe, Change{"history", live.MaxMsgsPerSubject, history})
+	}
+	if live.Replicas != replicas {
+		safe = append(safe, Change{"replicas", live.Replicas, replicas})
+	}
+	if liveCompression != cfg.Compression {
+		safe = append(safe, Change{"compression", liveCompression, cfg.Compression})
+	}
+	if live.MaxBytes != maxBytes {
+		safe = append(safe, Change{"max_bytes", live.MaxBytes, maxBytes})
+	}
+	if live.MaxMsgSize != maxValueSize {
+		safe = append(safe, Change{"max_value_size", live.MaxMsgSize, maxValueSize})
+	}
+	if live.Description != cfg.Description {
+		safe = append(safe, Change{"description", live.Description, cfg.Description})
+	}
+
+	// Per-message TTLs can be enabled on an existing stream but never disabled again
+	if live.SubjectDeleteMarkerTTL != cfg.LimitMarkerTTL {
+		change := Change{"limit_marker_ttl", live.SubjectDeleteMarkerTTL, cfg.LimitMarkerTTL}
+		if live.AllowMsgTTL && cfg.LimitMarkerTTL == 0 {
+			destructive = append(destructive, change)
+		} else {
+			safe = append(safe, change)
+		}
+	}
+
+	if live.Storage != cfg.Storage {
+		destructive = append(destructive, Change{"storage", live.Storage, cfg.Storage})
+	}
+
+	return safe, destructive
+}
@@ -0,0 +1,187 @@
+package kvbucket_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/internal/kvbucket"
+	"exampleapp/internal/natstest"
+)
+
+func TestDiff(t *testing.T) {
+	// live is the stream config the server reports for a bucket created with cfg
+	cfg := jetstream.KeyValueConfig{Bucket: "cache", TTL: time.Hour}
+	live := jetstream.StreamConfig{
+		MaxAge:            time.Hour,
+		MaxMsgsPerSubject: 1,
+		Replicas:          1,
+		MaxBytes:          -1,
+		MaxMsgSize:        -1,
+		Storage:           jetstream.FileStorage,
+	}
+
+	tests := []struct {
+		name        string
+		live        func(live *jetstream.StreamConfig)
+		cfg         func(cfg *jetstream.KeyValueConfig)
+		safe        []string
+		destructive []string
+	}{
+		{
+			name: "unchanged",
+		},
+		{
+			name: "ttl",
+			cfg:  func(cfg *jetstream.KeyValueConfig) { cfg.TTL = 2 * time.Hour },
+			safe: []string{"ttl"},
+		},
+		{
+			name: "history",
+			cfg:  func(cfg *jetstream.KeyValueConfig) { cfg.History = 5 },
+			safe: []string{"history"},
+		},
+		{
+			name: "ttl and history",
+			cfg: func(cfg *jetstream.KeyValueConfig) {
+				cfg.TTL = 0
+				cfg.History = 5
+			},
+			safe: []string{"ttl", "history"},
+		},
+		{
+			name: "enable msg ttl",
+			cfg:  func(cfg *jetstream.KeyValueConfig) { cfg.LimitMarkerTTL = time.Minute },
+			safe: []string{"limit_marker_ttl"},
+		},
+		{
+			name: "change msg ttl",
+			live: func(live *jetstream.StreamConfig) {
+				live.AllowMsgTTL = true
+				live.SubjectDeleteMarkerTTL = time.Minute
+			},
+			cfg:  func(cfg *jetstream.KeyValueConfig) { cfg.LimitMarkerTTL = time.Hour },
+			safe: []string{"limit_marker_ttl"},
+		},
+		{
+			name: "disable msg ttl",
+			live: func(live *jetstream.StreamConfig) {
+				live.AllowMsgTTL = true
+				live.SubjectDeleteMarkerTTL = time.Minute
+			},
+			destructive: []string{"limit_marker_ttl"},
+		},
+		{
+			name:        "storage",
+			cfg:         func(cfg *jetstream.KeyValueConfig) { cfg.Storage = jetstream.MemoryStorage },
+			destructive: []string{"storage"},
+		},
+		{
+			name: "safe and destructive",
+			cfg: func(cfg *jetstream.KeyValueConfig) {
+				cfg.TTL = time.Minute
+				cfg.Storage = jetstream.MemoryStorage
+			},
+			safe:        []string{"ttl"},
+			destructive: []string{"storage"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live, cfg := live, cfg
+			if tt.live != nil {
+				tt.live(&live)
+			}
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+
+			safe, destructive := kvbucket.Diff(live, cfg)
+
+			if got := fields(safe); !slices.Equal(got, tt.safe) {
+				t.Errorf("safe changes = %v, want %v", safe, tt.safe)
+			}
+			if got := fields(destructive); !slices.Equal(got, tt.destructive) {
+				t.Errorf("destructive changes = %v, want %v", destructive, tt.destructive)
+			}
+		})
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	ctx := context.Background()
+	js := natstest.JetStream(t)
+	logger := slog.New(slog.DiscardHandler)
+
+	cfg := jetstream.KeyValueConfig{Bucket: "cache", TTL: time.Hour, LimitMarkerTTL: time.Minute}
+
+	if _, err := kvbucket.Ensure(ctx, js, cfg, logger); err != nil {
+		t.Fatalf("Ensure creating: %v", err)
+	}
+
+	// The server's defaults for the created bucket don't count as drift
+	status := bucketStatus(t, js, cfg.Bucket)
+	if safe, destructive := kvbucket.Diff(status.StreamInfo().Config, cfg); len(safe)+len(destructive) > 0 {
+		t.Fatalf("created bucket differs from its config: safe %v, destructive %v", safe, destructive)
+	}
+
+	cfg.TTL = 2 * time.Hour
+	cfg.History = 3
+
+	if _, err := kvbucket.Ensure(ctx, js, cfg, logger); err != nil {
+		t.Fatalf("Ensure updating: %v", err)
+	}
+
+	status = bucketStatus(t, js, cfg.Bucket)
+	if status.TTL() != cfg.TTL || status.History() != int64(cfg.History) {
+		t.Fatalf("bucket TTL %v and history %d, want %v and %d", status.TTL(), status.History(), cfg.TTL, cfg.History)
+	}
+
+	for _, change := range []func(cfg *jetstream.KeyValueConfig){
+		func(cfg *jetstream.KeyValueConfig) { cfg.Storage = jetstream.MemoryStorage },
+		func(cfg *jetstream.KeyValueConfig) { cfg.LimitMarkerTTL = 0 },
+	} {
+		changed := cfg
+		changed.TTL = 3 * time.Hour
+		change(&changed)
+
+		if _, err := kvbucket.Ensure(ctx, js, changed, logger); !errors.Is(err, kvbucket.ErrDestructiveChange) {
+			t.Fatalf("Ensure: got %v, want %v", err, kvbucket.ErrDestructiveChange)
+		}
+	}
+
+	// Nothing is updated when any change is refused
+	if status = bucketStatus(t, js, cfg.Bucket); status.TTL() != cfg.TTL {
+		t.Fatalf("bucket TTL %v after refused changes, want %v", status.TTL(), cfg.TTL)
+	}
+}
+
+func bucketStatus(t *testing.T, js jetstream.JetStream, bucket string) *jetstream.KeyValueBucketStatus {
+	t.Helper()
+
+	kv, err := js.KeyValue(context.Background(), bucket)
+	if err != nil {
+		t.Fatalf("KeyValue(%q): %v", bucket, err)
+	}
+
+	status, err := kv.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	return status.(*jetstream.KeyValueBucketStatus)
+}
+
+func fields(changes []kvbucket.Change) []string {
+	var fields []string
+	for _, change := range changes {
+		fields = append(fields, change.Field)
+	}
+	return fields
+}
@@ -0,0 +1,314 @@
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockID is the Postgres advisory lock key held while migrating, so concurrent instances apply migrations in turn.
+const lockID = 8_413_920_551
+
+var (
+	ErrDirty          = errors.New("database is dirty, fix the failed migration and force the version")
+	ErrUnknownVersion = errors.New("database version has no matching migration")
+)
+
+// fileRx matches migration file names as created by `migrate create -seq`, e.g. 001_create_items_table.up.sql.
+var fileRx = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied.
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies migrations, tracking the current version in the schema_migrations table using the same layout as
+// golang-migrate, so databases previously migrated with the migrate CLI carry on from where they are.
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+// New loads the migrations in the root of fsys.
+func New(db *pgxpool.Pool, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint64]*Migration)
+
+	for _, entry := range entries {
+		matches := fileRx.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+
+		sql, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+
+		if matches[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		logger:     logger,
+	}, nil
+}
+
+// Version returns the current schema version, zero when no migrations have been applied, and whether the last
+// migration failed part way through.
+func (m *Migrator) Version(ctx context.Context) (uint64, bool, error) {
+	var (
+		version uint64
+		dirty   bool
+	)
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		var err error
+		version, dirty, err = currentVersion(ctx, conn)
+		return err
+	})
+
+	return version, dirty, err
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	version, _, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration, Applied: migration.Version <= version}
+	}
+
+	return statuses, nil
+}
+
+// Up applies every pending migration in order, returning how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		version, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= version {
+				continue
+			}
+
+			m.logger.Info("applying migration", slog.Uint64("version", migration.Version), slog.String("name", migration.Name))
+
+			if err = m.apply(ctx, conn, migration.Up, version, migration.Version); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+
+			version = migration.Version
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts up to steps applied migrations, newest first, returning how many were reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		version, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for reverted < steps && version > 0 {
+			i := slices.IndexFunc(m.migrations, func(migration Migration) bool {
+				return migration.Version == version
+			})
+			if i < 0 {
+				return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+			}
+
+			migration := m.migrations[i]
+
+			var previous uint64
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+
+			m.logger.Info("reverting migration", slog.Uint64("version", migration.Version), slog.String("name", migration.Name))
+
+			if err = m.apply(ctx, conn, migration.Down, version, previous); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+
+			version = previous
+			reverted++
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory lock, creating the version table first.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockID); err != nil {
+			m.logger.Error("unable to release migration lock", slog.String("error", err.Error()))
+		}
+	}()
+
+	if _, err = conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)"); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// cleanVersion returns the current version, refusing to continue from a dirty database.
+func (m *Migrator) cleanVersion(ctx context.Context, conn *pgxpool.Conn) (uint64, error) {
+	version, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	if dirty {
+		return 0, fmt.Errorf("%w: version %d", ErrDirty, version)
+	}
+
+	return version, nil
+}
+
+// Force sets the recorded version without running any migrations and clears the dirty flag, for recovering after a
+// failed migration has been fixed by hand.
+func (m *Migrator) Force(ctx context.Context, version uint64) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		return setVersion(ctx, conn, version)
+	})
+}
+
+// apply runs sql and records the move to version in a single transaction, so a failed migration leaves the database
+// at its previous version.
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, sql string, from, to uint64) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err = tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+
+	if err = setVersion(ctx, tx, to); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.logger.Info("migrated database", slog.Uint64("from", from), slog.Uint64("to", to))
+
+	return nil
+}
+
+type executor interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// setVersion records version as the clean current version, zero meaning no migrations are applied.
+func setVersion(ctx context.Context, db executor, version uint64) error {
+	if _, err := db.Exec(ctx, "DELETE FROM schema_migrations"); err != nil {
+		return err
+	}
+
+	if version == 0 {
+		return nil
+	}
+
+	_, err := db.Exec(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", int64(version))
+	return err
+}
+
+func currentVersion(ctx context.Context, conn *pgxpool.Conn) (uint64, bool, error) {
+	var (
+		version int64
+		dirty   bool
+	)
+
+	if err := conn.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	return uint64(version), dirty, nil
+}
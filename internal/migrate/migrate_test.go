@@ -0,0 +1,282 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrations are versioned so that sorting their names would apply 10 before 2.
+var migrations = fstest.MapFS{
+	"1_create_a.up.sql":    {Data: []byte("CREATE TABLE a (id INT)")},
+	"1_create_a.down.sql":  {Data: []byte("DROP TABLE a")},
+	"2_create_b.up.sql":    {Data: []byte("CREATE TABLE b (a_id INT)")},
+	"2_create_b.down.sql":  {Data: []byte("DROP TABLE b")},
+	"10_alter_b.up.sql":    {Data: []byte("ALTER TABLE b ADD COLUMN name TEXT")},
+	"10_alter_b.down.sql":  {Data: []byte("ALTER TABLE b DROP COLUMN name")},
+	"README.md":            {Data: []byte("not a migration")},
+	"3_not_sql.up.txt":     {Data: []byte("not a migration either")},
+	"nested/4_x.up.sql":    {Data: []byte("SELECT 1")},
+	"nested/4_x.down.sql":  {Data: []byte("SELECT 1")},
+	"20_broken.up.sql.bak": {Data: []byte("SELECT 1")},
+}
+
+// testPool returns a pool on a fresh schema of the disposable database named by APP_TEST_DATABASE_URI, so tests
+// don't share a schema_migrations table with each other or with other packages' tests.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	dsn := os.Getenv("APP_TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("APP_TEST_DATABASE_URI not set")
+	}
+
+	ctx := context.Background()
+	schema := "migrate_" + strings.ToLower(strings.NewReplacer("/", "_", "-", "_").Replace(t.Name()))
+
+	admin, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+	t.Cleanup(admin.Close)
+
+	if _, err = admin.Exec(ctx, fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE; CREATE SCHEMA %[1]s", schema)); err != nil {
+		t.Fatalf("unable to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec(context.Background(), fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+	})
+
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	return pool
+}
+
+func newMigrator(t *testing.T, pool *pgxpool.Pool, fsys fstest.MapFS) *Migrator {
+	t.Helper()
+
+	m, err := New(pool, fsys, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return m
+}
+
+func mustVersion(t *testing.T, m *Migrator, want uint64, wantDirty bool) {
+	t.Helper()
+
+	version, dirty, err := m.Version(context.Background())
+	if err != nil {
+		t.Fatalf("Version: %v", err)
+	}
+	if version != want || dirty != wantDirty {
+		t.Fatalf("Version: got %d (dirty %t), want %d (dirty %t)", version, dirty, want, wantDirty)
+	}
+}
+
+func TestNew(t *testing.T) {
+	m, err := New(nil, migrations, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var got []string
+	for _, migration := range m.migrations {
+		got = append(got, fmt.Sprintf("%d_%s", migration.Version, migration.Name))
+	}
+
+	if want := "1_create_a 2_create_b 10_alter_b"; strings.Join(got, " ") != want {
+		t.Fatalf("migrations: got %v, want %s", got, want)
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+	m := newMigrator(t, testPool(t), migrations)
+
+	mustVersion(t, m, 0, false)
+
+	applied, err := m.Up(ctx)
+	if err != nil || applied != 3 {
+		t.Fatalf("Up: got %d, %v, want 3 applied", applied, err)
+	}
+	mustVersion(t, m, 10, false)
+
+	// Nothing left to apply
+	if applied, err = m.Up(ctx); err != nil || applied != 0 {
+		t.Fatalf("Up again: got %d, %v, want 0 applied", applied, err)
+	}
+
+	reverted, err := m.Down(ctx, 2)
+	if err != nil || reverted != 2 {
+		t.Fatalf("Down(2): got %d, %v, want 2 reverted", reverted, err)
+	}
+	mustVersion(t, m, 1, false)
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	var got []string
+	for _, status := range statuses {
+		got = append(got, fmt.Sprintf("%d:%t", status.Version, status.Applied))
+	}
+	if want := "1:true 2:false 10:false"; strings.Join(got, " ") != want {
+		t.Fatalf("Status: got %v, want %s", got, want)
+	}
+
+	// Reverting more than was applied stops at zero
+	if reverted, err = m.Down(ctx, 5); err != nil || reverted != 1 {
+		t.Fatalf("Down(5): got %d, %v, want 1 reverted", reverted, err)
+	}
+	mustVersion(t, m, 0, false)
+}
+
+func TestFailedMigration(t *testing.T) {
+	ctx := context.Background()
+
+	broken := fstest.MapFS{}
+	for name, file := range migrations {
+		broken[name] = file
+	}
+	broken["10_alter_b.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE missing ADD COLUMN name TEXT")}
+
+	m := newMigrator(t, testPool(t), broken)
+
+	applied, err := m.Up(ctx)
+	if err == nil || applied != 2 {
+		t.Fatalf("Up: got %d, %v, want 2 applied and an error", applied, err)
+	}
+
+	// The failed migration rolled back with its version change
+	mustVersion(t, m, 2, false)
+}
+
+func TestDirty(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool(t)
+	m := newMigrator(t, pool, migrations)
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// As left by golang-migrate after a failed migration
+	if _, err := pool.Exec(ctx, "UPDATE schema_migrations SET dirty = true"); err != nil {
+		t.Fatal(err)
+	}
+	mustVersion(t, m, 10, true)
+
+	if _, err := m.Up(ctx); !errors.Is(err, ErrDirty) {
+		t.Fatalf("Up: got %v, want %v", err, ErrDirty)
+	}
+	if _, err := m.Down(ctx, 1); !errors.Is(err, ErrDirty) {
+		t.Fatalf("Down: got %v, want %v", err, ErrDirty)
+	}
+
+	if err := m.Force(ctx, 2); err != nil {
+		t.Fatalf("Force: %v", err)
+	}
+	mustVersion(t, m, 2, false)
+
+	// Migration 10 counts as unapplied again
+	if applied, err := m.Up(ctx); err == nil || applied != 0 {
+		t.Fatalf("Up after force: got %d, %v, want the already added column to fail", applied, err)
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+	ctx := context.Background()
+	m := newMigrator(t, testPool(t), migrations)
+
+	if err := m.Force(ctx, 5); err != nil {
+		t.Fatalf("Force: %v", err)
+	}
+
+	if _, err := m.Down(ctx, 1); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("Down: got %v, want %v", err, ErrUnknownVersion)
+	}
+
+	// Up carries on with the migrations after the unknown version
+	applied, err := m.Up(ctx)
+	if err == nil || applied != 0 {
+		t.Fatalf("Up: got %d, %v, want migration 10 to fail without tables from 1 and 2", applied, err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool(t)
+	m := newMigrator(t, pool, migrations)
+
+	// Another instance holding the lock blocks migrating
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		t.Fatal(err)
+	}
+
+	blocked, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	if _, err = m.Up(blocked); err == nil {
+		t.Fatal("Up: migrated while another instance held the lock")
+	}
+
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", lockID); err != nil {
+		t.Fatal(err)
+	}
+	conn.Release()
+
+	// Concurrent instances apply each migration once between them
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		total int
+	)
+
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			applied, err := newMigrator(t, pool, migrations).Up(ctx)
+			if err != nil {
+				t.Errorf("Up: %v", err)
+			}
+
+			mu.Lock()
+			total += applied
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if total != 3 {
+		t.Fatalf("applied %d migrations in total, want 3", total)
+	}
+	mustVersion(t, m, 10, false)
+}
@@ -0,0 +1,206 @@
+package natsstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// maxMergeAttempts bounds how many times a conflicting commit is merged and retried before giving up.
+const maxMergeAttempts = 3
+
+var (
+	ErrConflict = errors.New("session modified concurrently")
+)
+
+// MergeFunc resolves a commit conflict, returning the session data to write given the data this request read (base,
+// nil for a new session), the data it wants to commit (ours) and the data currently stored (theirs). All are encoded
+// scs session data.
+type MergeFunc func(ctx context.Context, token string, base, ours, theirs []byte) ([]byte, error)
+
+// WithOptimisticConcurrency makes CommitCtx write only if the session hasn't changed since this request's FindCtx,
+// which requires TrackRevisions to run before the scs session middleware. On conflict, merge is called and the commit
+// retried, or ErrConflict is returned when merge is nil. A session deleted since it was read stays deleted, the commit
+// failing with ErrConflict, so revoked sessions aren't brought back by requests still in flight.
+func WithOptimisticConcurrency(merge MergeFunc) Option {
+	return func(store *NatsStore) {
+		store.concurrency = true
+		store.merge = merge
+	}
+}
+
+// GobMerge is a MergeFunc for session data encoded with scs.GobCodec. It applies the changes this request made to
+// the values it read onto the stored session: keys it set or changed overwrite the stored values, keys it removed are
+// removed, and keys it left alone keep their stored values. The merged session gets the later of the two deadlines.
+func GobMerge(_ context.Context, _ string, base, ours, theirs []byte) ([]byte, error) {
+	codec := scs.GobCodec{}
+
+	var baseValues map[string]any
+	if base != nil {
+		var err error
+		if _, baseValues, err = codec.Decode(base); err != nil {
+			return nil, err
+		}
+	}
+
+	ourDeadline, ourValues, err := codec.Decode(ours)
+	if err != nil {
+		return nil, err
+	}
+
+	theirDeadline, values, err := codec.Decode(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	if values == nil {
+		values = make(map[string]any)
+	}
+
+	for key, value := range ourValues {
+		if baseValue, ok := baseValues[key]; !ok || !reflect.DeepEqual(value, baseValue) {
+			values[key] = value
+		}
+	}
+	for key := range baseValues {
+		if _, ok := ourValues[key]; !ok {
+			delete(values, key)
+		}
+	}
+
+	deadline := ourDeadline
+	if theirDeadline.After(deadline) {
+		deadline = theirDeadline
+	}
+
+	return codec.Encode(deadline, values)
+}
+
+type revisionsKey struct{}
+
+// revisions holds the KV revision of each session token read during a request, zero meaning the token was not found,
+// along with the session data read.
+type revisions struct {
+	mu   sync.Mutex
+	revs map[string]seen
+}
+
+type seen struct {
+	revision uint64
+	data     []byte
+}
+
+// TrackRevisions is middleware that lets FindCtx and CommitCtx share the session revisions seen by a request.
+// It must wrap the scs LoadAndSave middleware.
+func TrackRevisions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), revisionsKey{}, &revisions{revs: make(map[string]seen)})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// seeRevision records the revision and data of token read in ctx, if revisions are tracked.
+func seeRevision(ctx context.Context, token string, revision uint64, data []byte) {
+	if revs, ok := ctx.Value(revisionsKey{}).(*revisions); ok {
+		revs.mu.Lock()
+		revs.revs[token] = seen{revision: revision, data: data}
+		revs.mu.Unlock()
+	}
+}
+
+// seenRevision returns the revision and data of token read in ctx, reporting false if it was never read.
+func seenRevision(ctx context.Context, token string) (uint64, []byte, bool) {
+	revs, ok := ctx.Value(revisionsKey{}).(*revisions)
+	if !ok {
+		return 0, nil, false
+	}
+
+	revs.mu.Lock()
+	defer revs.mu.Unlock()
+
+	seen, ok := revs.revs[token]
+	return seen.revision, seen.data, ok
+}
+
+// commitRevision writes the session only if its latest revision is still the one seen in ctx, merging and retrying on
+// conflict when a MergeFunc is configured. base is the session data read in ctx.
+func (s *NatsStore) commitRevision(ctx context.Context, token string, base, b []byte, expiry time.Time, revision uint64) error {
+	for attempt := 1; ; attempt++ {
+		value, err := s.encode(token, b, expiry)
+		if err != nil {
+			return err
+		}
+
+		newRevision, err := s.publish(ctx, token, value, expiry, revision)
+		if err == nil {
+			seeRevision(ctx, token, newRevision, b)
+			return s.indexSession(ctx, token, b)
+		}
+
+		if !isWrongLastSequence(err) {
+			return err
+		}
+
+		if s.merge == nil || attempt == maxMergeAttempts {
+			return ErrConflict
+		}
+
+		entry, err := s.client.Get(ctx, s.prefix+token)
+		if err != nil {
+			if errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, jetstream.ErrKeyDeleted) {
+				// Deleted or revoked since it was read, which must not be undone
+				return ErrConflict
+			}
+			return err
+		}
+
+		theirs, _, ok := s.decode(token, entry.Value())
+		if !ok {
+			return ErrConflict
+		}
+
+		if b, err = s.merge(ctx, token, base, b, theirs); err != nil {
+			return err
+		}
+		base, revision = theirs, entry.Revision()
+	}
+}
+
+// publish writes value to the session key, expecting revision to be the key's latest revision (zero for a key that
+// doesn't exist), and returns the new revision.
+func (s *NatsStore) publish(ctx context.Context, token string, value []byte, expiry time.Time, revision uint64) (uint64, error) {
+	if revision == 0 {
+		// Create also succeeds over delete markers, which a zero expected sequence would not
+		var opts []jetstream.KVCreateOpt
+		if s.msgTTL {
+			opts = append(opts, jetstream.KeyTTL(roundTTL(time.Until(expiry))))
+		}
+		return s.client.Create(ctx, s.prefix+token, value, opts...)
+	}
+
+	opts := []jetstream.PublishOpt{jetstream.WithExpectLastSequencePerSubject(revision)}
+	if s.msgTTL {
+		opts = append(opts, jetstream.WithMsgTTL(roundTTL(time.Until(expiry))))
+	}
+
+	msg := &nats.Msg{Subject: s.subject(token), Data: value}
+	ack, err := s.js.PublishMsg(ctx, msg, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	return ack.Sequence, nil
+}
+
+// isWrongLastSequence reports whether err is JetStream rejecting a write because the key has moved on.
+func isWrongLastSequence(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
+}
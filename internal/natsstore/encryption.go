@@ -0,0 +1,108 @@
+package natsstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidKeyring = errors.New("invalid keyring")
+)
+
+// Keyring holds the AES-GCM keys used to seal session values at rest, indexed by key ID.
+// New values are always sealed with the primary key; values sealed with any other key in the ring can still be
+// opened, so keys can be rotated by adding a new primary and keeping the old key until its sessions expire.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring builds a Keyring from raw AES keys (16, 24 or 32 bytes). Key IDs must be 1-255 bytes long and primary
+// must be one of them.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("%w: primary key %q not found", ErrInvalidKeyring, primary)
+	}
+
+	kr := &Keyring{
+		primary: primary,
+		aeads:   make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for id, key := range keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("%w: key ID %q must be 1-255 bytes", ErrInvalidKeyring, id)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %q: %v", ErrInvalidKeyring, id, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %q: %v", ErrInvalidKeyring, id, err)
+		}
+
+		kr.aeads[id] = aead
+	}
+
+	return kr, nil
+}
+
+// WithEncryption seals every value written by the store with the keyring's primary key.
+func WithEncryption(kr *Keyring) Option {
+	return func(store *NatsStore) {
+		store.keyring = kr
+	}
+}
+
+// seal encrypts plaintext with the primary key, binding it to key so sealed values can't be moved between tokens.
+// The result is laid out as: key ID length (1 byte) | key ID | nonce | ciphertext.
+func (kr *Keyring) seal(key string, plaintext []byte) ([]byte, error) {
+	aead := kr.aeads[kr.primary]
+
+	value := make([]byte, 0, 1+len(kr.primary)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	value = append(value, byte(len(kr.primary)))
+	value = append(value, kr.primary...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	value = append(value, nonce...)
+
+	return aead.Seal(value, nonce, plaintext, []byte(key)), nil
+}
+
+// open reverses seal, reporting false for values that are malformed, sealed with an unknown key, or tampered with.
+func (kr *Keyring) open(key string, value []byte) ([]byte, bool) {
+	if len(value) < 1 {
+		return nil, false
+	}
+
+	idLen := int(value[0])
+	if len(value) < 1+idLen {
+		return nil, false
+	}
+
+	aead, ok := kr.aeads[string(value[1:1+idLen])]
+	if !ok {
+		return nil, false
+	}
+
+	value = value[1+idLen:]
+	if len(value) < aead.NonceSize() {
+		return nil, false
+	}
+
+	plaintext, err := aead.Open(nil, value[:aead.NonceSize()], value[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, false
+	}
+
+	return plaintext, true
+}
@@ -0,0 +1,84 @@
+package natsstore
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Op identifies a store operation reported to hooks.
+type Op string
+
+const (
+	OpFind   Op = "find"
+	OpCommit Op = "commit"
+	OpDelete Op = "delete"
+	OpAll    Op = "all"
+)
+
+// Event describes a completed store operation.
+type Event struct {
+	Op       Op
+	Duration time.Duration
+	Found    bool // OpFind only, false is a cache miss
+	Err      error
+}
+
+// Hook observes store operations, e.g. to record latency, hit/miss counts and errors as metrics.
+// Observe is called synchronously after every operation so it should return quickly.
+type Hook interface {
+	Observe(ctx context.Context, event Event)
+}
+
+// HookFunc adapts a function to the Hook interface.
+type HookFunc func(ctx context.Context, event Event)
+
+func (f HookFunc) Observe(ctx context.Context, event Event) {
+	f(ctx, event)
+}
+
+// WithLogger sets the logger the store reports to, by default a text logger on stdout.
+func WithLogger(logger *slog.Logger) Option {
+	return func(store *NatsStore) {
+		store.logger = logger
+	}
+}
+
+// WithHooks adds hooks that observe every find, commit, delete and all operation.
+func WithHooks(hooks ...Hook) Option {
+	return func(store *NatsStore) {
+		store.hooks = append(store.hooks, hooks...)
+	}
+}
+
+// observe logs a completed operation and reports it to the store hooks.
+func (s *NatsStore) observe(ctx context.Context, op Op, start time.Time, found bool, err error) {
+	event := Event{
+		Op:       op,
+		Duration: time.Since(start),
+		Found:    found,
+		Err:      err,
+	}
+
+	if err != nil {
+		s.logger.ErrorContext(
+			ctx,
+			"session store operation failed",
+			slog.String("op", string(op)),
+			slog.Duration("duration", event.Duration),
+			slog.String("error", err.Error()),
+		)
+	} else {
+		s.logger.DebugContext(
+			ctx,
+			"session store operation",
+			slog.String("op", string(op)),
+			slog.Duration("duration", event.Duration),
+			slog.Bool("found", found),
+		)
+	}
+
+	for _, hook := range s.hooks {
+		hook.Observe(ctx, event)
+	}
+}
@@ -2,19 +2,54 @@ package natsstore
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/internal/kvbucket"
+)
+
+const (
+	// formatMarker starts the header stored in front of every session payload, followed by formatVersion and the
+	// expiry. Gob streams never start with a zero byte, so values written as raw scs data before the header existed
+	// are told apart from headed ones.
+	formatMarker  = 0x00
+	formatVersion = 0x01
+
+	// headerLen is the size of the header: the marker, the version and the expiry.
+	headerLen = 2 + 8
+
+	// defaultTimeout bounds the context-free Store methods unless overridden with WithTimeout.
+	defaultTimeout = 5 * time.Second
+)
+
+var (
+	ErrNoUserIndex = errors.New("user index not enabled")
 )
 
 type NatsStore struct {
-	client jetstream.KeyValue
-	prefix string
-	logger *slog.Logger
+	js      jetstream.JetStream
+	client  jetstream.KeyValue
+	prefix  string
+	logger  *slog.Logger
+	msgTTL  bool // bucket allows per-message TTLs (LimitMarkerTTL is set)
+	timeout time.Duration
+	keyring *Keyring // nil stores values unencrypted
+
+	users         jetstream.KeyValue // user ID to session token index, nil when disabled
+	userID        UserIDFunc
+	revokeSubject string
+
+	concurrency bool // commit only over the revision seen by FindCtx, see WithOptimisticConcurrency
+	merge       MergeFunc
+
+	hooks []Hook
 }
 
 type Option func(*NatsStore)
@@ -25,26 +60,45 @@ func WithPrefix(prefix string) func(*NatsStore) {
 	}
 }
 
+// WithTimeout sets the timeout applied to Delete, Find, Commit and All, which have no caller supplied context.
+func WithTimeout(timeout time.Duration) Option {
+	return func(store *NatsStore) {
+		store.timeout = timeout
+	}
+}
+
+// New binds the store to the bucket described by cfg, creating it or reconciling its config as needed (see kvbucket.Ensure).
 func New(ctx context.Context, js jetstream.JetStream, cfg jetstream.KeyValueConfig, opts ...Option) (*NatsStore, error) {
 	var (
 		kv  jetstream.KeyValue
 		err error
 	)
 
-	if kv, err = js.CreateKeyValue(ctx, cfg); err != nil {
-		return nil, err
-	}
-
 	store := &NatsStore{
-		client: kv,
-		prefix: "scs.session.",
-		logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		js:      js,
+		prefix:  "scs.session.",
+		logger:  slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		timeout: defaultTimeout,
+
+		revokeSubject: "scs.revoked",
 	}
 
 	for _, opt := range opts {
 		opt(store)
 	}
 
+	if kv, err = kvbucket.Ensure(ctx, js, cfg, store.logger); err != nil {
+		return nil, err
+	}
+
+	status, err := kv.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	store.client = kv
+	store.msgTTL = status.LimitMarkerTTL() > 0
+
 	return store, nil
 }
 
@@ -57,34 +111,78 @@ func Must(store *NatsStore, err error) *NatsStore {
 }
 
 // CommitCtx adds a session token and data to the NATS KV store.
-// Note: the TTL param is discarded as TTL is a bucket property in NATS.
-func (s *NatsStore) CommitCtx(ctx context.Context, token string, b []byte, _ time.Time) error {
-	if _, err := s.client.Create(ctx, s.prefix+token, b); err != nil {
-		if errors.Is(err, nats.ErrKeyExists) {
-			_, err = s.client.Put(ctx, s.prefix+token, b)
+// When the bucket was created with a LimitMarkerTTL the key is published with a per-message TTL matching expiry,
+// otherwise the expiry stored alongside the payload is checked in FindCtx and the bucket TTL cleans up.
+func (s *NatsStore) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) (err error) {
+	defer func(start time.Time) { s.observe(ctx, OpCommit, start, false, err) }(time.Now())
+
+	return s.commit(ctx, token, b, expiry)
+}
+
+func (s *NatsStore) commit(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	ttl := time.Until(expiry)
+	if ttl <= 0 {
+		return s.delete(ctx, token)
+	}
+
+	if s.concurrency {
+		if revision, base, ok := seenRevision(ctx, token); ok {
+			return s.commitRevision(ctx, token, base, b, expiry, revision)
+		}
+	}
+
+	value, err := s.encode(token, b, expiry)
+	if err != nil {
+		return err
+	}
+
+	if s.msgTTL {
+		// KV Create only accepts a TTL for new keys, so publish to the key subject directly to cover overwrites too
+		msg := &nats.Msg{Subject: s.subject(token), Data: value}
+		if _, err := s.js.PublishMsg(ctx, msg, jetstream.WithMsgTTL(roundTTL(ttl))); err != nil {
+			return err
+		}
+		return s.indexSession(ctx, token, b)
+	}
+
+	if _, err := s.client.Create(ctx, s.prefix+token, value); err != nil {
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			_, err = s.client.Put(ctx, s.prefix+token, value)
 			if err != nil {
 				return err
 			}
-			return nil
+			return s.indexSession(ctx, token, b)
 		}
 		return err
 	}
-	return nil
+	return s.indexSession(ctx, token, b)
 }
 
 // DeleteCtx removes a token and data from the NATS KV store.
-func (s *NatsStore) DeleteCtx(ctx context.Context, token string) error {
+func (s *NatsStore) DeleteCtx(ctx context.Context, token string) (err error) {
+	defer func(start time.Time) { s.observe(ctx, OpDelete, start, false, err) }(time.Now())
+
+	return s.delete(ctx, token)
+}
+
+func (s *NatsStore) delete(ctx context.Context, token string) error {
 	if err := s.client.Purge(ctx, s.prefix+token); err != nil {
 		if errors.Is(err, nats.ErrKeyNotFound) {
-			return nil
+			return s.unindexSession(ctx, token)
 		}
 		return err
 	}
-	return nil
+	return s.unindexSession(ctx, token)
 }
 
 // FindCtx finds a token and data from the NATS KV store.
-func (s *NatsStore) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+func (s *NatsStore) FindCtx(ctx context.Context, token string) (b []byte, found bool, err error) {
+	defer func(start time.Time) { s.observe(ctx, OpFind, start, found, err) }(time.Now())
+
+	return s.find(ctx, token)
+}
+
+func (s *NatsStore) find(ctx context.Context, token string) ([]byte, bool, error) {
 	var (
 		entry jetstream.KeyValueEntry
 		err   error
@@ -92,24 +190,164 @@ func (s *NatsStore) FindCtx(ctx context.Context, token string) ([]byte, bool, er
 
 	if entry, err = s.client.Get(ctx, s.prefix+token); err != nil {
 		if errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, jetstream.ErrKeyDeleted) {
+			seeRevision(ctx, token, 0, nil)
 			return nil, false, nil
 		}
 		return nil, false, err
 	}
 
-	return entry.Value(), true, nil
+	b, expiry, ok := s.decode(token, entry.Value())
+	if !ok || expired(expiry, time.Now()) {
+		seeRevision(ctx, token, entry.Revision(), nil)
+		return nil, false, nil
+	}
+
+	seeRevision(ctx, token, entry.Revision(), b)
+
+	return b, true, nil
 }
 
-// Required for interface conformance
+// AllCtx returns the data for every active session under the store prefix, keyed by session token.
+// Deleted and expired entries are skipped.
+func (s *NatsStore) AllCtx(ctx context.Context) (sessions map[string][]byte, err error) {
+	defer func(start time.Time) { s.observe(ctx, OpAll, start, false, err) }(time.Now())
 
+	return s.all(ctx)
+}
+
+func (s *NatsStore) all(ctx context.Context) (map[string][]byte, error) {
+	watcher, err := s.client.WatchAll(ctx, jetstream.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+
+	sessions := make(map[string][]byte)
+	now := time.Now()
+
+	for {
+		select {
+		case entry := <-watcher.Updates():
+			// A nil entry marks the end of the initial values
+			if entry == nil {
+				return sessions, nil
+			}
+
+			token, ok := strings.CutPrefix(entry.Key(), s.prefix)
+			if !ok {
+				continue
+			}
+
+			b, expiry, ok := s.decode(token, entry.Value())
+			if !ok || expired(expiry, now) {
+				continue
+			}
+
+			sessions[token] = b
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// subject returns the JetStream subject backing the KV key for token.
+func (s *NatsStore) subject(token string) string {
+	return "$KV." + s.client.Bucket() + "." + s.prefix + token
+}
+
+// encode builds the stored value for a session, sealing it when encryption is enabled.
+func (s *NatsStore) encode(token string, b []byte, expiry time.Time) ([]byte, error) {
+	value := encodeValue(b, expiry)
+	if s.keyring == nil {
+		return value, nil
+	}
+
+	return s.keyring.seal(s.prefix+token, value)
+}
+
+// decode reverses encode, reporting false for malformed or tampered values.
+func (s *NatsStore) decode(token string, value []byte) ([]byte, time.Time, bool) {
+	if s.keyring != nil {
+		var ok bool
+		if value, ok = s.keyring.open(s.prefix+token, value); !ok {
+			return nil, time.Time{}, false
+		}
+	}
+
+	return decodeValue(value)
+}
+
+// encodeValue prefixes the session data with the format marker and version, and its expiry as big-endian unix
+// nanoseconds.
+func encodeValue(b []byte, expiry time.Time) []byte {
+	value := make([]byte, headerLen+len(b))
+	value[0] = formatMarker
+	value[1] = formatVersion
+	binary.BigEndian.PutUint64(value[2:], uint64(expiry.UnixNano()))
+	copy(value[headerLen:], b)
+	return value
+}
+
+// decodeValue splits a stored value into its session data and expiry, reporting false for malformed values and
+// those of an unknown format version. Values without the format marker were stored as raw scs data, they are returned
+// as they are with a zero expiry, leaving them to expire with the bucket TTL.
+func decodeValue(value []byte) ([]byte, time.Time, bool) {
+	if len(value) == 0 || value[0] != formatMarker {
+		return value, time.Time{}, len(value) > 0
+	}
+
+	if len(value) < headerLen || value[1] != formatVersion {
+		return nil, time.Time{}, false
+	}
+
+	expiry := time.Unix(0, int64(binary.BigEndian.Uint64(value[2:])))
+
+	return value[headerLen:], expiry, true
+}
+
+// expired reports whether a session with the given expiry has expired at now, a zero expiry being unknown.
+func expired(expiry, now time.Time) bool {
+	return !expiry.IsZero() && !now.Before(expiry)
+}
+
+// roundTTL rounds ttl up to whole seconds, the smallest unit (and minimum) JetStream accepts for message TTLs.
+func roundTTL(ttl time.Duration) time.Duration {
+	if rem := ttl % time.Second; rem != 0 {
+		ttl += time.Second - rem
+	}
+	return ttl
+}
+
+// Context-free variants for callers using the plain scs.Store and scs.IterableStore interfaces.
+
+// Delete is the same as DeleteCtx, using a background context bounded by the store timeout.
 func (s *NatsStore) Delete(token string) (err error) {
-	panic("Delete called, use DeleteCtx instead")
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	return s.DeleteCtx(ctx, token)
 }
 
+// Find is the same as FindCtx, using a background context bounded by the store timeout.
 func (s *NatsStore) Find(token string) (b []byte, found bool, err error) {
-	panic("Find called, use FindCtx instead")
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	return s.FindCtx(ctx, token)
 }
 
+// Commit is the same as CommitCtx, using a background context bounded by the store timeout.
 func (s *NatsStore) Commit(token string, b []byte, expiry time.Time) (err error) {
-	panic("Commit called, use CommitCtx instead")
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	return s.CommitCtx(ctx, token, b, expiry)
+}
+
+// All is the same as AllCtx, using a background context bounded by the store timeout.
+func (s *NatsStore) All() (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	return s.AllCtx(ctx)
 }
@@ -0,0 +1,566 @@
+package natsstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/internal/natsstore"
+	"exampleapp/internal/natstest"
+)
+
+func newStore(t *testing.T, js jetstream.JetStream, cfg jetstream.KeyValueConfig, opts ...natsstore.Option) *natsstore.NatsStore {
+	t.Helper()
+
+	opts = append([]natsstore.Option{natsstore.WithLogger(slog.New(slog.DiscardHandler))}, opts...)
+
+	store, err := natsstore.New(context.Background(), js, cfg, opts...)
+	if err != nil {
+		t.Fatalf("unable to create store: %v", err)
+	}
+
+	return store
+}
+
+func mustFind(t *testing.T, store *natsstore.NatsStore, token string, want []byte) {
+	t.Helper()
+
+	b, found, err := store.FindCtx(context.Background(), token)
+	if err != nil {
+		t.Fatalf("FindCtx(%q): %v", token, err)
+	}
+	if !found {
+		t.Fatalf("FindCtx(%q): not found", token)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("FindCtx(%q) = %q, want %q", token, b, want)
+	}
+}
+
+func mustNotFind(t *testing.T, store *natsstore.NatsStore, token string) {
+	t.Helper()
+
+	b, found, err := store.FindCtx(context.Background(), token)
+	if err != nil {
+		t.Fatalf("FindCtx(%q): %v", token, err)
+	}
+	if found {
+		t.Fatalf("FindCtx(%q) = %q, want not found", token, b)
+	}
+}
+
+// modes runs fn against a bucket with per-message TTLs and one relying on the stored expiry.
+func modes(t *testing.T, fn func(t *testing.T, js jetstream.JetStream, cfg jetstream.KeyValueConfig)) {
+	t.Run("message ttl", func(t *testing.T) {
+		fn(t, natstest.JetStream(t), jetstream.KeyValueConfig{Bucket: "sessions", TTL: time.Hour, LimitMarkerTTL: time.Minute})
+	})
+	t.Run("stored expiry", func(t *testing.T) {
+		fn(t, natstest.JetStream(t), jetstream.KeyValueConfig{Bucket: "sessions", TTL: time.Hour})
+	})
+}
+
+func TestCommitAndFind(t *testing.T) {
+	modes(t, func(t *testing.T, js jetstream.JetStream, cfg jetstream.KeyValueConfig) {
+		ctx := context.Background()
+		store := newStore(t, js, cfg)
+
+		mustNotFind(t, store, "missing")
+
+		if err := store.CommitCtx(ctx, "token", []byte("first"), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CommitCtx: %v", err)
+		}
+		mustFind(t, store, "token", []byte("first"))
+
+		if err := store.CommitCtx(ctx, "token", []byte("second"), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CommitCtx overwrite: %v", err)
+		}
+		mustFind(t, store, "token", []byte("second"))
+	})
+}
+
+func TestDelete(t *testing.T) {
+	modes(t, func(t *testing.T, js jetstream.JetStream, cfg jetstream.KeyValueConfig) {
+		ctx := context.Background()
+		store := newStore(t, js, cfg)
+
+		if err := store.CommitCtx(ctx, "token", []byte("data"), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CommitCtx: %v", err)
+		}
+
+		if err := store.DeleteCtx(ctx, "token"); err != nil {
+			t.Fatalf("DeleteCtx: %v", err)
+		}
+		mustNotFind(t, store, "token")
+
+		if err := store.DeleteCtx(ctx, "missing"); err != nil {
+			t.Fatalf("DeleteCtx missing token: %v", err)
+		}
+
+		// A deleted session can be committed again
+		if err := store.CommitCtx(ctx, "token", []byte("again"), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CommitCtx after delete: %v", err)
+		}
+		mustFind(t, store, "token", []byte("again"))
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for sessions to expire")
+	}
+
+	modes(t, func(t *testing.T, js jetstream.JetStream, cfg jetstream.KeyValueConfig) {
+		ctx := context.Background()
+		store := newStore(t, js, cfg)
+
+		if err := store.CommitCtx(ctx, "short", []byte("data"), time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("CommitCtx: %v", err)
+		}
+		if err := store.CommitCtx(ctx, "long", []byte("data"), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CommitCtx: %v", err)
+		}
+		mustFind(t, store, "short", []byte("data"))
+
+		time.Sleep(2 * time.Second)
+
+		mustNotFind(t, store, "short")
+		mustFind(t, store, "long", []byte("data"))
+
+		all, err := store.AllCtx(ctx)
+		if err != nil {
+			t.Fatalf("AllCtx: %v", err)
+		}
+		if _, ok := all["short"]; ok {
+			t.Fatal("AllCtx returned an expired session")
+		}
+
+		// Committing an already expired session removes it
+		if err = store.CommitCtx(ctx, "long", []byte("data"), time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("CommitCtx expired: %v", err)
+		}
+		mustNotFind(t, store, "long")
+	})
+
+	t.Run("message ttl removes key", func(t *testing.T) {
+		ctx := context.Background()
+		js := natstest.JetStream(t)
+		store := newStore(t, js, jetstream.KeyValueConfig{Bucket: "sessions", TTL: time.Hour, LimitMarkerTTL: time.Minute})
+
+		if err := store.CommitCtx(ctx, "token", []byte("data"), time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("CommitCtx: %v", err)
+		}
+
+		time.Sleep(2 * time.Second)
+
+		kv, err := js.KeyValue(ctx, "sessions")
+		if err != nil {
+			t.Fatalf("KeyValue: %v", err)
+		}
+		if _, err = kv.Get(ctx, "scs.session.token"); !errors.Is(err, jetstream.ErrKeyNotFound) {
+			t.Fatalf("Get expired key: got %v, want %v", err, jetstream.ErrKeyNotFound)
+		}
+	})
+}
+
+func TestPrefixIsolation(t *testing.T) {
+	ctx := context.Background()
+	js := natstest.JetStream(t)
+	cfg := jetstream.KeyValueConfig{Bucket: "sessions", TTL: time.Hour, LimitMarkerTTL: time.Minute}
+
+	a := newStore(t, js, cfg, natsstore.WithPrefix("a."))
+	b := newStore(t, js, cfg, natsstore.WithPrefix("b."))
+
+	if err := a.CommitCtx(ctx, "token", []byte("from a"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CommitCtx: %v", err)
+	}
+	if err := b.CommitCtx(ctx, "other", []byte("from b"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CommitCtx: %v", err)
+	}
+
+	mustFind(t, a, "token", []byte("from a"))
+	mustNotFind(t, b, "token")
+
+	all, err := a.AllCtx(ctx)
+	if err != nil {
+		t.Fatalf("AllCtx: %v", err)
+	}
+	if len(all) != 1 || !bytes.Equal(all["token"], []byte("from a")) {
+		t.Fatalf("AllCtx = %q, want only token", all)
+	}
+
+	if err = b.DeleteCtx(ctx, "token"); err != nil {
+		t.Fatalf("DeleteCtx: %v", err)
+	}
+	mustFind(t, a, "token", []byte("from a"))
+}
+
+func TestLegacyValues(t *testing.T) {
+	modes(t, func(t *testing.T, js jetstream.JetStream, cfg jetstream.KeyValueConfig) {
+		ctx := context.Background()
+		store := newStore(t, js, cfg)
+
+		// As committed before values carried a header: the raw scs data
+		legacy, err := scs.GobCodec{}.Encode(time.Now().Add(time.Hour), map[string]any{"userID": "42"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		kv, err := js.KeyValue(ctx, cfg.Bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = kv.Put(ctx, "scs.session.legacy", legacy); err != nil {
+			t.Fatal(err)
+		}
+
+		mustFind(t, store, "legacy", legacy)
+
+		all, err := store.AllCtx(ctx)
+		if err != nil {
+			t.Fatalf("AllCtx: %v", err)
+		}
+		if !bytes.Equal(all["legacy"], legacy) {
+			t.Fatalf("AllCtx = %q, want the legacy session", all)
+		}
+
+		// Rewritten with a header on the next commit
+		if err = store.CommitCtx(ctx, "legacy", legacy, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CommitCtx: %v", err)
+		}
+		mustFind(t, store, "legacy", legacy)
+
+		// An unknown format version isn't mistaken for session data
+		if _, err = kv.Put(ctx, "scs.session.future", append([]byte{0x00, 0x02}, legacy...)); err != nil {
+			t.Fatal(err)
+		}
+		mustNotFind(t, store, "future")
+	})
+}
+
+func TestContextFreeMethods(t *testing.T) {
+	store := newStore(t, natstest.JetStream(t), jetstream.KeyValueConfig{Bucket: "sessions"}, natsstore.WithTimeout(time.Second))
+
+	var _ scs.Store = store
+	var _ scs.IterableStore = store
+
+	if err := store.Commit("token", []byte("data"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	b, found, err := store.Find("token")
+	if err != nil || !found || !bytes.Equal(b, []byte("data")) {
+		t.Fatalf("Find = %q, %v, %v", b, found, err)
+	}
+
+	all, err := store.All()
+	if err != nil || len(all) != 1 {
+		t.Fatalf("All = %q, %v", all, err)
+	}
+
+	if err = store.Delete("token"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	mustNotFind(t, store, "token")
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	js := natstest.JetStream(t)
+	cfg := jetstream.KeyValueConfig{Bucket: "sessions"}
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	oldRing, err := natsstore.NewKeyring("old", map[string][]byte{"old": oldKey})
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	newRing, err := natsstore.NewKeyring("new", map[string][]byte{"old": oldKey, "new": newKey})
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+
+	before := newStore(t, js, cfg, natsstore.WithEncryption(oldRing))
+	after := newStore(t, js, cfg, natsstore.WithEncryption(newRing))
+
+	if err = before.CommitCtx(ctx, "token", []byte("secret"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CommitCtx: %v", err)
+	}
+
+	kv, err := js.KeyValue(ctx, "sessions")
+	if err != nil {
+		t.Fatalf("KeyValue: %v", err)
+	}
+	entry, err := kv.Get(ctx, "scs.session.token")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if bytes.Contains(entry.Value(), []byte("secret")) {
+		t.Fatal("session data stored in plain text")
+	}
+
+	// Rotated keyrings still open values sealed with the old key
+	mustFind(t, after, "token", []byte("secret"))
+
+	tampered := bytes.Clone(entry.Value())
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err = kv.Put(ctx, "scs.session.token", tampered); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	mustNotFind(t, after, "token")
+
+	if _, err = natsstore.NewKeyring("missing", map[string][]byte{"old": oldKey}); !errors.Is(err, natsstore.ErrInvalidKeyring) {
+		t.Fatalf("NewKeyring with missing primary: got %v, want %v", err, natsstore.ErrInvalidKeyring)
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	ctx := context.Background()
+	js := natstest.JetStream(t)
+
+	users, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "session-users"})
+	if err != nil {
+		t.Fatalf("CreateKeyValue: %v", err)
+	}
+
+	store := newStore(t, js, jetstream.KeyValueConfig{Bucket: "sessions"}, natsstore.WithUserIndex(users, natsstore.GobUserID("userID")))
+
+	session := func(userID string) []byte {
+		b, err := scs.GobCodec{}.Encode(time.Now().Add(time.Hour), map[string]any{"userID": userID})
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return b
+	}
+
+	sessions := map[string][]byte{
+		"laptop": session("alice@example.com"),
+		"phone":  session("alice@example.com"),
+		"other":  session("bob"),
+	}
+	for token, b := range sessions {
+		if err = store.CommitCtx(ctx, token, b, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CommitCtx: %v", err)
+		}
+	}
+
+	revoked, err := store.Revoked(ctx, "phone")
+	if err != nil {
+		t.Fatalf("Revoked: %v", err)
+	}
+
+	n, err := store.RevokeUser(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("RevokeUser: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("RevokeUser revoked %d sessions, want 2", n)
+	}
+
+	select {
+	case <-revoked:
+	case <-time.After(time.Second):
+		t.Fatal("no revocation event received")
+	}
+
+	mustNotFind(t, store, "laptop")
+	mustNotFind(t, store, "phone")
+	mustFind(t, store, "other", sessions["other"])
+}
+
+// requestCtx returns a context carrying revision tracking, as seen by handlers behind TrackRevisions.
+func requestCtx() context.Context {
+	var ctx context.Context
+	natsstore.TrackRevisions(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	return ctx
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	modes(t, func(t *testing.T, js jetstream.JetStream, cfg jetstream.KeyValueConfig) {
+		ctx := context.Background()
+		expiry := time.Now().Add(time.Hour)
+
+		strict := newStore(t, js, cfg, natsstore.WithOptimisticConcurrency(nil))
+		merging := newStore(t, js, cfg, natsstore.WithOptimisticConcurrency(func(_ context.Context, _ string, _, ours, theirs []byte) ([]byte, error) {
+			return append(bytes.Clone(theirs), ours...), nil
+		}))
+
+		if err := strict.CommitCtx(ctx, "token", []byte("a"), expiry); err != nil {
+			t.Fatalf("CommitCtx: %v", err)
+		}
+
+		first, second := requestCtx(), requestCtx()
+		if _, _, err := strict.FindCtx(first, "token"); err != nil {
+			t.Fatalf("FindCtx: %v", err)
+		}
+		if _, _, err := strict.FindCtx(second, "token"); err != nil {
+			t.Fatalf("FindCtx: %v", err)
+		}
+
+		if err := strict.CommitCtx(first, "token", []byte("b"), expiry); err != nil {
+			t.Fatalf("CommitCtx first: %v", err)
+		}
+		if err := strict.CommitCtx(second, "token", []byte("c"), expiry); !errors.Is(err, natsstore.ErrConflict) {
+			t.Fatalf("CommitCtx second: got %v, want %v", err, natsstore.ErrConflict)
+		}
+		mustFind(t, strict, "token", []byte("b"))
+
+		first, second = requestCtx(), requestCtx()
+		if _, _, err := merging.FindCtx(first, "token"); err != nil {
+			t.Fatalf("FindCtx: %v", err)
+		}
+		if _, _, err := merging.FindCtx(second, "token"); err != nil {
+			t.Fatalf("FindCtx: %v", err)
+		}
+
+		if err := merging.CommitCtx(first, "token", []byte("d"), expiry); err != nil {
+			t.Fatalf("CommitCtx first: %v", err)
+		}
+		if err := merging.CommitCtx(second, "token", []byte("e"), expiry); err != nil {
+			t.Fatalf("CommitCtx second: %v", err)
+		}
+		mustFind(t, merging, "token", []byte("de"))
+
+		// A session deleted after it was read stays deleted, whether or not conflicts are merged
+		for _, store := range []*natsstore.NatsStore{strict, merging} {
+			if err := store.CommitCtx(ctx, "token", []byte("f"), expiry); err != nil {
+				t.Fatalf("CommitCtx: %v", err)
+			}
+
+			request := requestCtx()
+			if _, _, err := store.FindCtx(request, "token"); err != nil {
+				t.Fatalf("FindCtx: %v", err)
+			}
+			if err := store.DeleteCtx(ctx, "token"); err != nil {
+				t.Fatalf("DeleteCtx: %v", err)
+			}
+
+			if err := store.CommitCtx(request, "token", []byte("g"), expiry); !errors.Is(err, natsstore.ErrConflict) {
+				t.Fatalf("CommitCtx after delete: got %v, want %v", err, natsstore.ErrConflict)
+			}
+			mustNotFind(t, store, "token")
+		}
+	})
+}
+
+func TestGobMerge(t *testing.T) {
+	codec := scs.GobCodec{}
+	early, late := time.Now().Add(time.Hour).Round(0), time.Now().Add(2*time.Hour).Round(0)
+
+	encode := func(deadline time.Time, values map[string]any) []byte {
+		t.Helper()
+		b, err := codec.Encode(deadline, values)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		base   map[string]any // nil for a session this request created
+		ours   map[string]any
+		theirs map[string]any
+		want   map[string]any
+	}{
+		{
+			name:   "new session",
+			ours:   map[string]any{"theme": "dark", "userID": "alice"},
+			theirs: map[string]any{"theme": "light", "flash": "saved"},
+			want:   map[string]any{"theme": "dark", "userID": "alice", "flash": "saved"},
+		},
+		{
+			name:   "both set",
+			base:   map[string]any{"userID": "alice"},
+			ours:   map[string]any{"userID": "alice", "theme": "dark"},
+			theirs: map[string]any{"userID": "alice", "flash": "saved"},
+			want:   map[string]any{"userID": "alice", "theme": "dark", "flash": "saved"},
+		},
+		{
+			name:   "unchanged keys keep theirs",
+			base:   map[string]any{"userID": "alice", "theme": "light"},
+			ours:   map[string]any{"userID": "alice", "theme": "light", "flash": "saved"},
+			theirs: map[string]any{"userID": "alice", "theme": "dark"},
+			want:   map[string]any{"userID": "alice", "theme": "dark", "flash": "saved"},
+		},
+		{
+			name:   "logout removes the user",
+			base:   map[string]any{"userID": "alice", "theme": "light"},
+			ours:   map[string]any{"theme": "light"},
+			theirs: map[string]any{"userID": "alice", "theme": "light", "flash": "saved"},
+			want:   map[string]any{"theme": "light", "flash": "saved"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var base []byte
+			if tt.base != nil {
+				base = encode(early, tt.base)
+			}
+
+			merged, err := natsstore.GobMerge(context.Background(), "token", base, encode(early, tt.ours), encode(late, tt.theirs))
+			if err != nil {
+				t.Fatalf("GobMerge: %v", err)
+			}
+
+			deadline, values, err := codec.Decode(merged)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			if !deadline.Equal(late) {
+				t.Errorf("deadline: got %v, want %v", deadline, late)
+			}
+			if !maps.Equal(values, tt.want) {
+				t.Errorf("values: got %v, want %v", values, tt.want)
+			}
+		})
+	}
+}
+
+func TestHooks(t *testing.T) {
+	ctx := context.Background()
+
+	var events []natsstore.Event
+	store := newStore(t, natstest.JetStream(t), jetstream.KeyValueConfig{Bucket: "sessions"}, natsstore.WithHooks(
+		natsstore.HookFunc(func(_ context.Context, event natsstore.Event) {
+			events = append(events, event)
+		}),
+	))
+
+	_ = store.CommitCtx(ctx, "token", []byte("data"), time.Now().Add(time.Hour))
+	_, _, _ = store.FindCtx(ctx, "token")
+	_, _, _ = store.FindCtx(ctx, "missing")
+	_ = store.DeleteCtx(ctx, "token")
+
+	want := []struct {
+		op    natsstore.Op
+		found bool
+	}{
+		{natsstore.OpCommit, false},
+		{natsstore.OpFind, true},
+		{natsstore.OpFind, false},
+		{natsstore.OpDelete, false},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, event := range events {
+		if event.Op != want[i].op || event.Found != want[i].found || event.Err != nil {
+			t.Errorf("event %d = %+v, want op %s found %v", i, event, want[i].op, want[i].found)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package natsstore
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// UserIDFunc extracts the ID of the user owning a session from its encoded session data.
+type UserIDFunc func(b []byte) (string, bool)
+
+// GobUserID returns a UserIDFunc that decodes session data with scs.GobCodec and reads the user ID from key,
+// which must hold a string or fmt.Stringer. Use the same key the application stores the authenticated user under.
+func GobUserID(key string) UserIDFunc {
+	return func(b []byte) (string, bool) {
+		_, values, err := scs.GobCodec{}.Decode(b)
+		if err != nil {
+			return "", false
+		}
+
+		switch id := values[key].(type) {
+		case string:
+			return id, id != ""
+		case fmt.Stringer:
+			return id.String(), true
+		default:
+			return "", false
+		}
+	}
+}
+
+// WithUserIndex maintains a secondary index in users mapping each user ID to their session tokens, which is required
+// for RevokeUser. The index bucket should share the session bucket's TTL so stale entries age out.
+func WithUserIndex(users jetstream.KeyValue, userID UserIDFunc) Option {
+	return func(store *NatsStore) {
+		store.users = users
+		store.userID = userID
+	}
+}
+
+// WithRevocationSubject sets the subject prefix revocation events are published under, defaults to "scs.revoked".
+func WithRevocationSubject(subject string) Option {
+	return func(store *NatsStore) {
+		store.revokeSubject = subject
+	}
+}
+
+// RevokeUser deletes every session belonging to userID and publishes a revocation event for each token,
+// returning the number of sessions revoked. On failure the sessions deleted so far are counted along with the error.
+func (s *NatsStore) RevokeUser(ctx context.Context, userID string) (int, error) {
+	if s.users == nil {
+		return 0, ErrNoUserIndex
+	}
+
+	tokens, err := s.indexKeys(ctx, userKey(userID)+".*")
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+
+	for _, key := range tokens {
+		_, token, _ := strings.Cut(key, ".")
+
+		if err = s.DeleteCtx(ctx, token); err != nil {
+			return revoked, err
+		}
+		revoked++
+
+		if err = s.js.Conn().Publish(s.revokeSubject+"."+token, []byte(userID)); err != nil {
+			return revoked, err
+		}
+	}
+
+	return revoked, nil
+}
+
+// Revoked returns a channel that is closed when the session identified by token is revoked, allowing long-lived
+// handlers such as Datastar SSE streams to end the connection. The subscription is released when ctx is done, so
+// pass the request context, and end the stream by cancelling the context the handler writes events with:
+//
+//	ctx, cancel := context.WithCancel(r.Context())
+//	defer cancel()
+//	revoked, err := store.Revoked(ctx, sessionManager.Token(ctx))
+//	...
+//	go func() {
+//		select {
+//		case <-revoked:
+//			cancel()
+//		case <-ctx.Done():
+//		}
+//	}()
+func (s *NatsStore) Revoked(ctx context.Context, token string) (<-chan struct{}, error) {
+	revoked := make(chan struct{})
+	var once sync.Once
+
+	sub, err := s.js.Conn().Subscribe(s.revokeSubject+"."+token, func(_ *nats.Msg) {
+		once.Do(func() { close(revoked) })
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := sub.Unsubscribe(); err != nil {
+			s.logger.Error("unable to unsubscribe from session revocations", slog.String("error", err.Error()))
+		}
+	}()
+
+	return revoked, nil
+}
+
+// indexSession records token against the user owning the session data, if any.
+func (s *NatsStore) indexSession(ctx context.Context, token string, b []byte) error {
+	if s.users == nil {
+		return nil
+	}
+
+	userID, ok := s.userID(b)
+	if !ok {
+		return nil
+	}
+
+	if _, err := s.users.Put(ctx, userKey(userID)+"."+token, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unindexSession removes token from the user index, whichever user it belongs to.
+func (s *NatsStore) unindexSession(ctx context.Context, token string) error {
+	if s.users == nil {
+		return nil
+	}
+
+	keys, err := s.indexKeys(ctx, "*."+token)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err = s.users.Purge(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexKeys lists the user index keys matching filter.
+func (s *NatsStore) indexKeys(ctx context.Context, filter string) ([]string, error) {
+	lister, err := s.users.ListKeysFiltered(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range lister.Keys() {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// userKey encodes a user ID into a KV safe key segment.
+func userKey(userID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(userID))
+}
@@ -0,0 +1,64 @@
+// Package natstest provides throwaway embedded NATS servers for tests.
+package natstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Server starts an in-process NATS server with JetStream enabled, storing data in a temp dir, and returns a client
+// connection to it. The server and connection are shut down when the test completes.
+func Server(tb testing.TB) *nats.Conn {
+	tb.Helper()
+
+	opts := &server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  tb.TempDir(),
+		NoSigs:    true,
+		NoLog:     true,
+	}
+
+	srv, err := server.NewServer(opts)
+	if err != nil {
+		tb.Fatalf("unable to create NATS server: %v", err)
+	}
+
+	go srv.Start()
+
+	if !srv.ReadyForConnections(5 * time.Second) {
+		srv.Shutdown()
+		tb.Fatal("NATS server not ready")
+	}
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		srv.Shutdown()
+		tb.Fatalf("unable to connect to NATS server: %v", err)
+	}
+
+	tb.Cleanup(func() {
+		nc.Close()
+		srv.Shutdown()
+		srv.WaitForShutdown()
+	})
+
+	return nc
+}
+
+// JetStream starts a throwaway server as Server does and returns a JetStream context for it.
+func JetStream(tb testing.TB) jetstream.JetStream {
+	tb.Helper()
+
+	js, err := jetstream.New(Server(tb))
+	if err != nil {
+		tb.Fatalf("unable to create JetStream context: %v", err)
+	}
+
+	return js
+}
@@ -0,0 +1,99 @@
+// Package secret keeps sensitive config values out of logs and formatted output.
+package secret
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const redacted = "[redacted]"
+
+// String is a sensitive string such as a password. It prints, logs and marshals as [redacted], Reveal returns the
+// real value. It implements flag.Value so secrets can be set from the command line.
+type String string
+
+// Reveal returns the secret value, only pass it to what needs it.
+func (s String) Reveal() string {
+	return string(s)
+}
+
+func (s String) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s String) GoString() string {
+	return fmt.Sprintf("secret.String(%q)", s.String())
+}
+
+func (s String) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s String) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Set implements flag.Value.
+func (s *String) Set(value string) error {
+	*s = String(value)
+	return nil
+}
+
+// Redactor scrubs known secret values from log output, catching secrets that end up inside other strings such as
+// connection strings or error messages. Install it with slog.HandlerOptions.ReplaceAttr.
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// NewRedactor returns a Redactor for the given secret values, empty values are ignored.
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.Add(secrets...)
+	return r
+}
+
+// Add redacts more secret values, such as those read when the config is reloaded. Empty values are ignored.
+func (r *Redactor) Add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	secrets = slices.DeleteFunc(append(slices.Clone(r.secrets), secrets...), func(s string) bool { return s == "" })
+
+	// Longest first, so a secret containing another is replaced whole, then by value so duplicates compact
+	slices.SortFunc(secrets, func(a, b string) int { return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b)) })
+
+	r.secrets = slices.Compact(secrets)
+}
+
+// Redact replaces every secret in s.
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// ReplaceAttr redacts secrets in log attributes, including the message, for use in slog.HandlerOptions.
+func (r *Redactor) ReplaceAttr(_ []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.Redact(a.Value.String()))
+	case slog.KindAny:
+		// Errors and other values are checked as they'd be printed
+		if s := fmt.Sprint(a.Value.Any()); r.Redact(s) != s {
+			a.Value = slog.StringValue(r.Redact(s))
+		}
+	}
+	return a
+}
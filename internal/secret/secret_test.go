@@ -0,0 +1,105 @@
+package secret_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+
+	"exampleapp/internal/secret"
+)
+
+func TestString(t *testing.T) {
+	s := secret.String("hunter2")
+
+	for _, format := range []string{"%v", "%s", "%q", "%+v", "%#v"} {
+		if got := fmt.Sprintf(format, s); strings.Contains(got, "hunter2") {
+			t.Errorf("%s formats as %s", format, got)
+		}
+	}
+
+	// Inside structs too, as when a whole config is printed
+	config := struct{ Password secret.String }{s}
+	for _, format := range []string{"%v", "%+v", "%#v"} {
+		if got := fmt.Sprintf(format, config); strings.Contains(got, "hunter2") {
+			t.Errorf("%s formats a struct as %s", format, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("connecting", slog.Any("password", s), "token", s)
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("logged as %s", buf.String())
+	}
+
+	if s.Reveal() != "hunter2" {
+		t.Errorf("Reveal = %q, want hunter2", s.Reveal())
+	}
+
+	// An unset secret has nothing to hide
+	if got := secret.String("").String(); got != "" {
+		t.Errorf("empty secret formats as %q", got)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	const password = "p@ss word/1"
+
+	// As config.secrets lists them, so the password is caught inside connection strings
+	userinfo := strings.TrimPrefix(url.UserPassword("", password).String(), ":")
+	r := secret.NewRedactor(password, url.PathEscape(password), url.QueryEscape(password), userinfo, "")
+
+	dsn := fmt.Sprintf("postgres://user:%s@localhost/db?password=%s", url.PathEscape(password), url.QueryEscape(password))
+
+	tests := []struct {
+		name string
+		attr slog.Attr
+	}{
+		{"string", slog.String("dsn", "password is "+password)},
+		{"path escaped", slog.String("dsn", dsn)},
+		{"error", slog.Any("error", fmt.Errorf("connecting to %s: %w", dsn, errors.New("refused")))},
+		{"stringer", slog.Any("url", &url.URL{Scheme: "postgres", User: url.UserPassword("user", password), Host: "localhost"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.ReplaceAttr(nil, tt.attr)
+
+			if got.Key != tt.attr.Key {
+				t.Errorf("key = %q, want %q", got.Key, tt.attr.Key)
+			}
+
+			s := got.Value.String()
+			for _, form := range []string{password, url.PathEscape(password), url.QueryEscape(password), userinfo} {
+				if strings.Contains(s, form) {
+					t.Errorf("ReplaceAttr = %s, still contains %s", s, form)
+				}
+			}
+			if !strings.Contains(s, "[redacted]") {
+				t.Errorf("ReplaceAttr = %s, want it redacted", s)
+			}
+		})
+	}
+
+	// Values without secrets are left as they are
+	if got := r.ReplaceAttr(nil, slog.Int("port", 5432)); got.Value.Kind() != slog.KindInt64 {
+		t.Errorf("ReplaceAttr changed an int to %v", got.Value.Kind())
+	}
+	if got := r.ReplaceAttr(nil, slog.Any("error", errors.New("refused"))); got.Value.Kind() != slog.KindAny {
+		t.Errorf("ReplaceAttr changed a clean error to %v", got.Value.Kind())
+	}
+
+	// Messages are redacted too once installed in a handler, and secrets added later are caught
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ReplaceAttr: r.ReplaceAttr}))
+
+	r.Add("reloaded-token")
+	logger.Info("connecting to "+dsn, slog.String("token", "reloaded-token"))
+
+	if out := buf.String(); strings.Contains(out, "p@ss") || strings.Contains(out, "reloaded-token") {
+		t.Errorf("logged %s", out)
+	}
+}
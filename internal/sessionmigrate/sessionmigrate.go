@@ -0,0 +1,93 @@
+package sessionmigrate
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// Options controls a Copy.
+type Options struct {
+	// DryRun reads and decodes every session without writing to the destination.
+	DryRun bool
+
+	// Codec decodes session data to find each session's deadline, defaults to scs.GobCodec.
+	Codec scs.Codec
+
+	// Progress, if set, is called after every ProgressEvery sessions are processed, or after each one when
+	// ProgressEvery is zero.
+	Progress      func(Report)
+	ProgressEvery int
+}
+
+// Report counts the sessions processed by a Copy.
+type Report struct {
+	Total   int // sessions read from the source
+	Copied  int // sessions written to the destination (or that would be, in a dry run)
+	Expired int // sessions skipped because their deadline has passed
+	Failed  int // sessions that couldn't be decoded
+}
+
+// Copy writes every active session in src to dst, preserving its token and data and expiring it at the deadline
+// recorded in the session data, so users stay logged in for their remaining lifetime.
+// Stores implementing the scs context interfaces are called with ctx, as the session manager does.
+// Sessions that can't be decoded are counted as failed and skipped; errors reading src or writing dst stop the copy.
+func Copy(ctx context.Context, src scs.IterableStore, dst scs.Store, opts Options) (Report, error) {
+	var report Report
+
+	codec := opts.Codec
+	if codec == nil {
+		codec = scs.GobCodec{}
+	}
+
+	every := max(opts.ProgressEvery, 1)
+
+	sessions, err := all(ctx, src)
+	if err != nil {
+		return report, err
+	}
+
+	now := time.Now()
+
+	for token, b := range sessions {
+		report.Total++
+
+		deadline, _, err := codec.Decode(b)
+		switch {
+		case err != nil:
+			report.Failed++
+		case !deadline.After(now):
+			report.Expired++
+		default:
+			if !opts.DryRun {
+				if err = commit(ctx, dst, token, b, deadline); err != nil {
+					return report, err
+				}
+			}
+			report.Copied++
+		}
+
+		if opts.Progress != nil && report.Total%every == 0 {
+			opts.Progress(report)
+		}
+	}
+
+	return report, nil
+}
+
+func all(ctx context.Context, store scs.IterableStore) (map[string][]byte, error) {
+	if store, ok := store.(scs.IterableCtxStore); ok {
+		return store.AllCtx(ctx)
+	}
+
+	return store.All()
+}
+
+func commit(ctx context.Context, store scs.Store, token string, b []byte, expiry time.Time) error {
+	if store, ok := store.(scs.CtxStore); ok {
+		return store.CommitCtx(ctx, token, b, expiry)
+	}
+
+	return store.Commit(token, b, expiry)
+}
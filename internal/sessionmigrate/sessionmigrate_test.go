@@ -0,0 +1,148 @@
+package sessionmigrate_test
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/internal/natsstore"
+	"exampleapp/internal/natstest"
+	"exampleapp/internal/sessionmigrate"
+)
+
+// stores returns a source and destination store on separate buckets of one server, with the source holding three
+// active sessions, one whose data has passed its deadline and one that doesn't decode.
+func stores(t *testing.T) (*natsstore.NatsStore, *natsstore.NatsStore, map[string][]byte) {
+	t.Helper()
+
+	ctx := context.Background()
+	js := natstest.JetStream(t)
+
+	open := func(bucket string) *natsstore.NatsStore {
+		store, err := natsstore.New(ctx, js, jetstream.KeyValueConfig{Bucket: bucket, TTL: time.Hour}, natsstore.WithLogger(slog.New(slog.DiscardHandler)))
+		if err != nil {
+			t.Fatalf("unable to create store: %v", err)
+		}
+		return store
+	}
+	src, dst := open("from"), open("to")
+
+	encode := func(deadline time.Time) []byte {
+		b, err := scs.GobCodec{}.Encode(deadline, map[string]any{"userID": "42"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	active := map[string][]byte{
+		"a": encode(time.Now().Add(time.Hour)),
+		"b": encode(time.Now().Add(2 * time.Hour)),
+		"c": encode(time.Now().Add(3 * time.Hour)),
+	}
+	sessions := map[string][]byte{
+		"expired": encode(time.Now().Add(-time.Minute)),
+		"garbage": []byte("not gob"),
+	}
+	for token, b := range active {
+		sessions[token] = b
+	}
+
+	// The store's own expiry outlives the deadline in the data, as after a session TTL was shortened
+	for token, b := range sessions {
+		if err := src.CommitCtx(ctx, token, b, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CommitCtx(%q): %v", token, err)
+		}
+	}
+
+	return src, dst, active
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	src, dst, active := stores(t)
+
+	report, err := sessionmigrate.Copy(ctx, src, dst, sessionmigrate.Options{})
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	if want := (sessionmigrate.Report{Total: 5, Copied: 3, Expired: 1, Failed: 1}); report != want {
+		t.Fatalf("Copy = %+v, want %+v", report, want)
+	}
+
+	copied, err := dst.AllCtx(ctx)
+	if err != nil {
+		t.Fatalf("AllCtx: %v", err)
+	}
+	if len(copied) != len(active) {
+		t.Fatalf("copied %d sessions, want %d", len(copied), len(active))
+	}
+
+	for token, b := range active {
+		if string(copied[token]) != string(b) {
+			t.Fatalf("session %q = %q, want %q", token, copied[token], b)
+		}
+	}
+}
+
+func TestCopyDryRun(t *testing.T) {
+	ctx := context.Background()
+	src, dst, _ := stores(t)
+
+	report, err := sessionmigrate.Copy(ctx, src, dst, sessionmigrate.Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	if want := (sessionmigrate.Report{Total: 5, Copied: 3, Expired: 1, Failed: 1}); report != want {
+		t.Fatalf("Copy = %+v, want %+v", report, want)
+	}
+
+	copied, err := dst.AllCtx(ctx)
+	if err != nil {
+		t.Fatalf("AllCtx: %v", err)
+	}
+	if len(copied) != 0 {
+		t.Fatalf("dry run copied %d sessions", len(copied))
+	}
+}
+
+func TestCopyProgress(t *testing.T) {
+	tests := []struct {
+		every int
+		want  []int
+	}{
+		{every: 0, want: []int{1, 2, 3, 4, 5}},
+		{every: 1, want: []int{1, 2, 3, 4, 5}},
+		{every: 2, want: []int{2, 4}},
+		{every: 5, want: []int{5}},
+		{every: 10, want: nil},
+	}
+
+	for _, tt := range tests {
+		src, dst, _ := stores(t)
+
+		var got []int
+
+		_, err := sessionmigrate.Copy(context.Background(), src, dst, sessionmigrate.Options{
+			DryRun:        true,
+			ProgressEvery: tt.every,
+			Progress: func(report sessionmigrate.Report) {
+				got = append(got, report.Total)
+			},
+		})
+		if err != nil {
+			t.Fatalf("Copy: %v", err)
+		}
+
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("every %d: progress at %v, want %v", tt.every, got, tt.want)
+		}
+	}
+}
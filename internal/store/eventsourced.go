@@ -0,0 +1,521 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	ItemCreated = "ItemCreated"
+	ItemRenamed = "ItemRenamed"
+	ItemDeleted = "ItemDeleted"
+
+	// EventStream is the JetStream stream holding the item event log, one subject per item: item_events.<id>.
+	EventStream = "ITEM_EVENTS"
+
+	// projectionConsumer is the durable consumer tracking how far the projections have applied the event log.
+	projectionConsumer = "items-projection"
+)
+
+// uuidRx matches item ids, which are also used as subject tokens so must never contain wildcards or dots.
+var uuidRx = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// ItemEvent is an entry in the item event log. Each event carries the full item state after the change, so the latest
+// event for an item is its current state.
+type ItemEvent struct {
+	Type  string    `json:"type"`
+	Item  Item      `json:"item"`
+	At    time.Time `json:"at"`
+	Actor string    `json:"actor,omitempty"` // see WithActor
+}
+
+// EventItemStore is an ItemStore whose canonical state is the ITEM_EVENTS stream. Writes append events, checking the
+// item's last sequence so concurrent changes to the same item conflict rather than interleave, and single items are
+// read straight from the log. The Postgres items table and KV cache are projections of the log maintained by
+// Projector, and back List and Count.
+type EventItemStore struct {
+	js         jetstream.JetStream
+	stream     jetstream.Stream
+	projection *ItemStore
+}
+
+// NewEventItemStore creates or updates the ITEM_EVENTS stream and returns a store appending to it. projection is used
+// for queries across items.
+func NewEventItemStore(ctx context.Context, js jetstream.JetStream, projection *ItemStore) (*EventItemStore, error) {
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:              EventStream,
+		Subjects:          []string{"item_events.>"},
+		MaxMsgsPerSubject: -1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventItemStore{
+		js:         js,
+		stream:     stream,
+		projection: projection,
+	}, nil
+}
+
+// Create appends an ItemCreated event for a new item, filling in its id and creation time.
+func (s *EventItemStore) Create(ctx context.Context, item *Item) error {
+	if err := validate(item); err != nil {
+		return err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	item.ID = id
+	item.CreatedAt = time.Now().UTC()
+	item.Version = 1
+
+	return s.append(ctx, ItemCreated, item, 0)
+}
+
+// Get returns the current state of an item from the event log.
+func (s *EventItemStore) Get(ctx context.Context, id string) (*Item, error) {
+	event, _, err := s.last(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event.Item, nil
+}
+
+// Update appends an ItemRenamed event. It fails with ErrConflict if item.Version isn't the current version, or if the
+// item changes between reading and appending.
+func (s *EventItemStore) Update(ctx context.Context, id string, item *Item) error {
+	if err := validate(item); err != nil {
+		return err
+	}
+
+	event, seq, err := s.last(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if item.Version != event.Item.Version {
+		return ErrConflict
+	}
+
+	item.ID = event.Item.ID
+	item.CreatedAt = event.Item.CreatedAt
+	item.Version++
+
+	return s.append(ctx, ItemRenamed, item, seq)
+}
+
+// Delete appends an ItemDeleted event.
+func (s *EventItemStore) Delete(ctx context.Context, id string) error {
+	event, seq, err := s.last(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.append(ctx, ItemDeleted, &event.Item, seq)
+}
+
+// List returns a page of items from the Postgres projection.
+func (s *EventItemStore) List(ctx context.Context, params ListParams) ([]*Item, string, error) {
+	return s.projection.List(ctx, params)
+}
+
+// Count counts items in the Postgres projection.
+func (s *EventItemStore) Count(ctx context.Context, namePrefix string) (int, error) {
+	return s.projection.Count(ctx, namePrefix)
+}
+
+// History returns every change made to an item, oldest first, including those of deleted items. It reads back the
+// item's events, so revision IDs are stream sequences.
+func (s *EventItemStore) History(ctx context.Context, id string) ([]*Revision, error) {
+	if !uuidRx.MatchString(id) {
+		return nil, ErrNotFound
+	}
+
+	// Read up to the item's latest event as of now, later events are left for the next call
+	last, err := s.stream.GetLastMsgForSubject(ctx, eventSubject(id))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var (
+		revisions []*Revision
+		before    *Item
+	)
+
+	// Walk the item's events, each get returning the first on its subject at or after seq
+	for seq := uint64(1); seq <= last.Sequence; {
+		msg, err := s.stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(eventSubject(id)))
+		if err != nil {
+			return nil, err
+		}
+
+		var event ItemEvent
+		if err = json.Unmarshal(msg.Data, &event); err != nil {
+			return nil, fmt.Errorf("item %s event %d: %w", id, msg.Sequence, err)
+		}
+
+		revision := &Revision{
+			ID:     int64(msg.Sequence),
+			ItemID: id,
+			Action: revisionActions[event.Type],
+			Actor:  event.Actor,
+			At:     event.At,
+			Before: before,
+		}
+
+		item := event.Item
+		if event.Type == ItemDeleted {
+			before = nil
+		} else {
+			revision.After = &item
+			before = &item
+		}
+
+		revisions = append(revisions, revision)
+		seq = msg.Sequence + 1
+	}
+
+	return revisions, nil
+}
+
+// revisionActions maps event types to the actions recorded in item history.
+var revisionActions = map[string]string{
+	ItemCreated: EventItemCreated,
+	ItemRenamed: EventItemUpdated,
+	ItemDeleted: EventItemDeleted,
+}
+
+// last returns the latest event for a live item and its stream sequence.
+func (s *EventItemStore) last(ctx context.Context, id string) (*ItemEvent, uint64, error) {
+	if !uuidRx.MatchString(id) {
+		return nil, 0, ErrNotFound
+	}
+
+	msg, err := s.stream.GetLastMsgForSubject(ctx, eventSubject(id))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, err
+	}
+
+	var event ItemEvent
+	if err = json.Unmarshal(msg.Data, &event); err != nil {
+		return nil, 0, fmt.Errorf("item %s event %d: %w", id, msg.Sequence, err)
+	}
+
+	if event.Type == ItemDeleted {
+		return nil, 0, ErrNotFound
+	}
+
+	return &event, msg.Sequence, nil
+}
+
+// append publishes an event for item, expecting lastSeq to be the latest sequence on the item's subject (zero for a
+// new item).
+func (s *EventItemStore) append(ctx context.Context, eventType string, item *Item, lastSeq uint64) error {
+	data, err := json.Marshal(ItemEvent{Type: eventType, Item: *item, At: time.Now().UTC(), Actor: ActorFrom(ctx)})
+	if err != nil {
+		return err
+	}
+
+	msg := &nats.Msg{Subject: eventSubject(item.ID), Data: data}
+
+	if _, err = s.js.PublishMsg(ctx, msg, jetstream.WithExpectLastSequencePerSubject(lastSeq)); err != nil {
+		if isWrongLastSequence(err) {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+func eventSubject(id string) string {
+	return "item_events." + id
+}
+
+// newID returns a random (version 4) UUID.
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// Projector applies the item event log to the Postgres items table, the item history and the KV cache, tracking its
+// position with a durable consumer so it resumes where it left off. Events already applied are skipped, so
+// redeliveries are harmless.
+type Projector struct {
+	db     *pgxpool.Pool
+	js     jetstream.JetStream
+	cache  jetstream.KeyValue
+	logger *slog.Logger
+}
+
+func NewProjector(db *pgxpool.Pool, js jetstream.JetStream, cache jetstream.KeyValue, logger *slog.Logger) *Projector {
+	return &Projector{
+		db:     db,
+		js:     js,
+		cache:  cache,
+		logger: logger,
+	}
+}
+
+// Run applies events as they are appended until ctx is done.
+func (p *Projector) Run(ctx context.Context) error {
+	consumer, err := p.consumer(ctx)
+	if err != nil {
+		return err
+	}
+
+	consumeCtx, err := consumer.Consume(func(msg jetstream.Msg) {
+		p.handle(ctx, msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	consumeCtx.Drain()
+	<-consumeCtx.Closed()
+
+	return nil
+}
+
+// Rebuild replaces the items table and item history with those replayed from the whole event log in a single
+// transaction, then purges the cached copies of the items before and after, returning the number of events applied.
+// Other keys in the cache bucket are left alone. Run must not be running while rebuilding.
+func (p *Projector) Rebuild(ctx context.Context) (int, error) {
+	stream, err := p.js.Stream(ctx, EventStream)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	last := info.State.LastSeq
+
+	ids := make(map[string]struct{})
+	applied := 0
+
+	err = pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, "SELECT id FROM items")
+		if err != nil {
+			return err
+		}
+		existing, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return err
+		}
+		for _, id := range existing {
+			ids[id] = struct{}{}
+		}
+
+		if _, err = tx.Exec(ctx, "DELETE FROM item_revisions"); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(ctx, "DELETE FROM items"); err != nil {
+			return err
+		}
+
+		// Read the log up to its end as of now, events appended meanwhile are left to the consumer
+		for seq := uint64(1); seq <= last; seq++ {
+			msg, err := stream.GetMsg(ctx, seq)
+			if err != nil {
+				if errors.Is(err, jetstream.ErrMsgNotFound) {
+					continue // removed from the stream
+				}
+				return err
+			}
+
+			event, err := decodeEvent(msg.Data)
+			if err != nil {
+				return fmt.Errorf("item event %d: %w", seq, err)
+			}
+
+			if err = applyEvent(ctx, tx, event); err != nil {
+				return fmt.Errorf("item event %d: %w", seq, err)
+			}
+			ids[event.Item.ID] = struct{}{}
+			applied++
+
+			if applied%1000 == 0 {
+				p.logger.Info("replaying item events", slog.Int("applied", applied))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Resume projecting after the replayed events
+	if err = p.js.DeleteConsumer(ctx, EventStream, projectionConsumer); err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return applied, err
+	}
+	if _, err = p.js.CreateConsumer(ctx, EventStream, consumerConfig(last+1)); err != nil {
+		return applied, err
+	}
+
+	for id := range ids {
+		if err = p.cache.Purge(ctx, id); err != nil {
+			return applied, err
+		}
+	}
+
+	return applied, nil
+}
+
+// consumer returns the projection's durable consumer, creating it to start from the beginning of the log if needed.
+func (p *Projector) consumer(ctx context.Context) (jetstream.Consumer, error) {
+	consumer, err := p.js.Consumer(ctx, EventStream, projectionConsumer)
+	if !errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return consumer, err
+	}
+
+	return p.js.CreateConsumer(ctx, EventStream, consumerConfig(0))
+}
+
+// consumerConfig configures the projection's durable consumer, delivering from startSeq or from the beginning when
+// it is zero.
+func consumerConfig(startSeq uint64) jetstream.ConsumerConfig {
+	cfg := jetstream.ConsumerConfig{
+		Durable:       projectionConsumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+		MaxAckPending: 1, // apply strictly in order
+	}
+
+	if startSeq > 0 {
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = startSeq
+	}
+
+	return cfg
+}
+
+// handle applies a delivered event, leaving it for redelivery if that fails.
+func (p *Projector) handle(ctx context.Context, msg jetstream.Msg) {
+	if err := p.apply(ctx, msg.Data()); err != nil {
+		p.logger.Error("unable to project item event", slog.String("subject", msg.Subject()), slog.String("error", err.Error()))
+		if err = msg.Nak(); err != nil {
+			p.logger.Error("unable to nak item event", slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		p.logger.Error("unable to ack item event", slog.String("error", err.Error()))
+	}
+}
+
+// apply updates the Postgres and cache projections with a single event.
+func (p *Projector) apply(ctx context.Context, data []byte) error {
+	event, err := decodeEvent(data)
+	if err != nil {
+		return err
+	}
+
+	if err = pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		return applyEvent(ctx, tx, event)
+	}); err != nil {
+		return err
+	}
+
+	item := event.Item
+
+	if event.Type == ItemDeleted {
+		return p.cache.Purge(ctx, item.ID)
+	}
+
+	itemJson, err := json.Marshal(&item)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.cache.Put(ctx, item.ID, itemJson)
+	return err
+}
+
+func decodeEvent(data []byte) (*ItemEvent, error) {
+	var event ItemEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// applyEvent updates the items table and item history with an event within tx, skipping events already applied.
+func applyEvent(ctx context.Context, tx pgx.Tx, event *ItemEvent) error {
+	item := event.Item
+
+	var (
+		before  *Item
+		current Item
+	)
+
+	err := tx.QueryRow(ctx, "SELECT id, name, created_at, version FROM items WHERE id = $1 FOR UPDATE", item.ID).
+		Scan(&current.ID, &current.Name, &current.CreatedAt, &current.Version)
+	switch {
+	case err == nil:
+		before = &current
+	case !errors.Is(err, pgx.ErrNoRows):
+		return err
+	}
+
+	if event.Type == ItemDeleted {
+		if before == nil {
+			return nil
+		}
+
+		if _, err = tx.Exec(ctx, "DELETE FROM items WHERE id = $1", item.ID); err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, EventItemDeleted, event.Actor, event.At, before, nil)
+	}
+
+	if before != nil && before.Version >= item.Version {
+		return nil
+	}
+
+	if _, err = tx.Exec(
+		ctx,
+		"INSERT INTO items (id, name, created_at, version) VALUES ($1, $2, $3, $4) "+
+			"ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, version = EXCLUDED.version",
+		item.ID, item.Name, item.CreatedAt, item.Version,
+	); err != nil {
+		return err
+	}
+
+	return insertRevision(ctx, tx, revisionActions[event.Type], event.Actor, event.At, before, &item)
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Revision is an entry in an item's history, recording one change and who made it.
+type Revision struct {
+	ID     int64     `json:"id"`
+	ItemID string    `json:"item_id"`
+	Action string    `json:"action"` // EventItemCreated, EventItemUpdated or EventItemDeleted
+	Actor  string    `json:"actor"`  // empty when the change wasn't made on behalf of anyone, see WithActor
+	At     time.Time `json:"at"`
+	Before *Item     `json:"before"` // nil for creations
+	After  *Item     `json:"after"`  // nil for deletions
+}
+
+type actorKey struct{}
+
+// WithActor returns a context attributing item changes made with it to actor in their history.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set by WithActor, or an empty string.
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// writeRevision records an item change made now in the item's history within tx.
+func writeRevision(ctx context.Context, tx pgx.Tx, action string, before, after *Item) error {
+	return insertRevision(ctx, tx, action, ActorFrom(ctx), time.Now().UTC(), before, after)
+}
+
+// insertRevision records an item change made by actor at the given time in the item's history within tx.
+func insertRevision(ctx context.Context, tx pgx.Tx, action, actor string, at time.Time, before, after *Item) error {
+	changed := after
+	if changed == nil {
+		changed = before
+	}
+
+	beforeJson, err := revisionJson(before)
+	if err != nil {
+		return err
+	}
+
+	afterJson, err := revisionJson(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		"INSERT INTO item_revisions (item_id, action, actor, changed_at, before, after) VALUES ($1, $2, $3, $4, $5, $6)",
+		changed.ID, action, actor, at, beforeJson, afterJson,
+	)
+	return err
+}
+
+// revisionJson encodes one side of a revision, a nil item becomes SQL NULL.
+func revisionJson(item *Item) ([]byte, error) {
+	if item == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(item)
+}
+
+// History returns every change made to an item, oldest first, including those of deleted items.
+func (s *ItemStore) History(ctx context.Context, id string) ([]*Revision, error) {
+	if !uuidRx.MatchString(id) {
+		return nil, ErrNotFound
+	}
+
+	rows, err := s.db.Query(
+		ctx,
+		"SELECT id, item_id, action, actor, changed_at, before, after FROM item_revisions WHERE item_id = $1 ORDER BY id",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*Revision
+
+	for rows.Next() {
+		var (
+			revision              Revision
+			beforeJson, afterJson []byte
+		)
+
+		if err = rows.Scan(&revision.ID, &revision.ItemID, &revision.Action, &revision.Actor, &revision.At, &beforeJson, &afterJson); err != nil {
+			return nil, err
+		}
+
+		if beforeJson != nil {
+			if err = json.Unmarshal(beforeJson, &revision.Before); err != nil {
+				return nil, err
+			}
+		}
+		if afterJson != nil {
+			if err = json.Unmarshal(afterJson, &revision.After); err != nil {
+				return nil, err
+			}
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return revisions, nil
+}
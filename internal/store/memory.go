@@ -0,0 +1,223 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Items = (*MemoryItemStore)(nil)
+
+// MemoryItemStore is an in-memory Items implementation with the same semantics as ItemStore, for tests and local
+// development. The zero value is not usable, create one with NewMemoryItemStore.
+type MemoryItemStore struct {
+	mu        sync.RWMutex
+	items     map[string]Item
+	revisions map[string][]*Revision
+	revision  int64 // last revision ID
+}
+
+func NewMemoryItemStore() *MemoryItemStore {
+	return &MemoryItemStore{
+		items:     make(map[string]Item),
+		revisions: make(map[string][]*Revision),
+	}
+}
+
+// Create adds an item, filling in its id and creation time.
+func (s *MemoryItemStore) Create(ctx context.Context, item *Item) error {
+	if err := validate(item); err != nil {
+		return err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item.ID = id
+	item.CreatedAt = time.Now().UTC().Truncate(time.Microsecond) // Postgres timestamp precision
+	item.Version = 1
+	s.items[id] = *item
+	s.record(ctx, EventItemCreated, nil, item)
+
+	return nil
+}
+
+func (s *MemoryItemStore) Get(_ context.Context, id string) (*Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &item, nil
+}
+
+// Update renames an item, failing with ErrConflict unless item.Version is the current version, and fills in the rest
+// of item from the stored copy.
+func (s *MemoryItemStore) Update(ctx context.Context, id string, item *Item) error {
+	if err := validate(item); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.items[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if item.Version != stored.Version {
+		return ErrConflict
+	}
+
+	before := stored
+
+	stored.Name = item.Name
+	stored.Version++
+	s.items[id] = stored
+	*item = stored
+
+	s.record(ctx, EventItemUpdated, &before, item)
+
+	return nil
+}
+
+func (s *MemoryItemStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.items[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(s.items, id)
+	s.record(ctx, EventItemDeleted, &stored, nil)
+
+	return nil
+}
+
+// List returns a page of items in creation order and the cursor for the next page, empty when there are no more.
+func (s *MemoryItemStore) List(_ context.Context, params ListParams) ([]*Item, string, error) {
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	var (
+		afterCreated time.Time
+		afterID      string
+	)
+
+	if params.Cursor != "" {
+		var err error
+		if afterCreated, afterID, err = decodeCursor(params.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := make([]*Item, 0, limit)
+
+	for _, item := range s.items {
+		if !strings.HasPrefix(item.Name, params.NamePrefix) {
+			continue
+		}
+		if params.Cursor != "" && compareKeys(item.CreatedAt, item.ID, afterCreated, afterID) <= 0 {
+			continue
+		}
+
+		items = append(items, &item)
+	}
+
+	slices.SortFunc(items, func(a, b *Item) int {
+		return compareKeys(a.CreatedAt, a.ID, b.CreatedAt, b.ID)
+	})
+
+	if len(items) <= limit {
+		return items, "", nil
+	}
+
+	items = items[:limit]
+	last := items[limit-1]
+
+	return items, encodeCursor(last.CreatedAt, last.ID), nil
+}
+
+// Count returns the number of items whose name starts with namePrefix, or all items when it is empty.
+func (s *MemoryItemStore) Count(_ context.Context, namePrefix string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+
+	for _, item := range s.items {
+		if strings.HasPrefix(item.Name, namePrefix) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// History returns every change made to an item, oldest first, including those of deleted items.
+func (s *MemoryItemStore) History(_ context.Context, id string) ([]*Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions, ok := s.revisions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return slices.Clone(revisions), nil
+}
+
+// record appends a change to an item's history, s.mu must be held. before and after are copied.
+func (s *MemoryItemStore) record(ctx context.Context, action string, before, after *Item) {
+	s.revision++
+
+	revision := &Revision{
+		ID:     s.revision,
+		Action: action,
+		Actor:  ActorFrom(ctx),
+		At:     time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	if before != nil {
+		b := *before
+		revision.Before = &b
+		revision.ItemID = b.ID
+	}
+	if after != nil {
+		a := *after
+		revision.After = &a
+		revision.ItemID = a.ID
+	}
+
+	s.revisions[revision.ItemID] = append(s.revisions[revision.ItemID], revision)
+}
+
+// compareKeys orders items by (created_at, id) like the items keyset index. Ids are lowercase UUIDs, so comparing
+// them as strings matches Postgres' uuid ordering.
+func compareKeys(aCreated time.Time, aID string, bCreated time.Time, bID string) int {
+	if c := aCreated.Compare(bCreated); c != 0 {
+		return c
+	}
+
+	return cmp.Compare(aID, bID)
+}
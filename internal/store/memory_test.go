@@ -0,0 +1,14 @@
+package store_test
+
+import (
+	"testing"
+
+	"exampleapp/internal/store"
+	"exampleapp/internal/store/storetest"
+)
+
+func TestMemoryItemStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Items {
+		return store.NewMemoryItemStore()
+	})
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	EventItemCreated = "created"
+	EventItemUpdated = "updated"
+	EventItemDeleted = "deleted"
+
+	// OutboxStream is the JetStream stream item changes are relayed to, on subjects items.<event>.<id>.
+	OutboxStream = "ITEMS"
+
+	// outboxLockID is the advisory lock key held while relaying, so instances relay one at a time and in order.
+	outboxLockID = 5_120_774_318
+
+	defaultRelayInterval  = time.Second
+	defaultRelayBatch     = 100
+	defaultRelayRetention = 24 * time.Hour
+)
+
+// writeOutbox records an item change in the outbox within tx, to be published by the Relay once tx commits.
+func writeOutbox(ctx context.Context, tx pgx.Tx, event string, item *Item) error {
+	payload, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO item_outbox (item_id, event_type, payload) VALUES ($1, $2, $3)", item.ID, event, payload)
+	return err
+}
+
+// Relay publishes item changes recorded in the outbox to the ITEMS stream and applies them to the cache, marking them
+// published only once both have succeeded. Changes are delivered at least once; the outbox id is used as the message
+// ID so JetStream drops redeliveries within its duplicate window. Published changes are deleted from the outbox once
+// older than the retention window.
+type Relay struct {
+	db        *pgxpool.Pool
+	js        jetstream.JetStream
+	cache     jetstream.KeyValue
+	logger    *slog.Logger
+	interval  time.Duration
+	batch     int
+	retention time.Duration
+}
+
+type RelayOption func(*Relay)
+
+// WithRelayInterval sets how often the outbox is polled, defaults to one second.
+func WithRelayInterval(interval time.Duration) RelayOption {
+	return func(relay *Relay) {
+		relay.interval = interval
+	}
+}
+
+// WithRelayBatch sets the maximum number of changes relayed per poll, defaults to 100.
+func WithRelayBatch(batch int) RelayOption {
+	return func(relay *Relay) {
+		relay.batch = batch
+	}
+}
+
+// WithRelayRetention sets how long published changes are kept in the outbox before being deleted, defaults to 24
+// hours.
+func WithRelayRetention(retention time.Duration) RelayOption {
+	return func(relay *Relay) {
+		relay.retention = retention
+	}
+}
+
+// NewRelay creates a Relay, creating or updating the ITEMS stream.
+func NewRelay(ctx context.Context, db *pgxpool.Pool, js jetstream.JetStream, cache jetstream.KeyValue, logger *slog.Logger, opts ...RelayOption) (*Relay, error) {
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       OutboxStream,
+		Subjects:   []string{"items.>"},
+		Duplicates: 2 * time.Minute,
+	}); err != nil {
+		return nil, err
+	}
+
+	relay := &Relay{
+		db:        db,
+		js:        js,
+		cache:     cache,
+		logger:    logger,
+		interval:  defaultRelayInterval,
+		batch:     defaultRelayBatch,
+		retention: defaultRelayRetention,
+	}
+
+	for _, opt := range opts {
+		opt(relay)
+	}
+
+	return relay, nil
+}
+
+// Run relays outbox changes and prunes those published until ctx is done. Failures are logged and retried on the next
+// poll.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Keep going while full batches are waiting
+			for {
+				relayed, err := r.Flush(ctx)
+				if err != nil {
+					if !errors.Is(err, context.Canceled) {
+						r.logger.Error("unable to relay item outbox", slog.String("error", err.Error()))
+					}
+					break
+				}
+				if relayed < r.batch {
+					break
+				}
+			}
+
+			if _, err := r.Prune(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				r.logger.Error("unable to prune item outbox", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// Prune deletes changes published longer ago than the retention window, returning how many were deleted.
+func (r *Relay) Prune(ctx context.Context) (int64, error) {
+	tag, err := r.db.Exec(ctx, "DELETE FROM item_outbox WHERE published_at < $1", time.Now().Add(-r.retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Flush relays one batch of pending changes in order, returning how many were relayed. It relays nothing if another
+// instance is already relaying.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	var (
+		relayed  int
+		relayErr error
+	)
+
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var locked bool
+		if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", outboxLockID).Scan(&locked); err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		rows, err := tx.Query(
+			ctx,
+			"SELECT id, item_id, event_type, payload FROM item_outbox WHERE published_at IS NULL ORDER BY id LIMIT $1",
+			r.batch,
+		)
+		if err != nil {
+			return err
+		}
+
+		type change struct {
+			id      int64
+			itemID  string
+			event   string
+			payload []byte
+		}
+
+		changes, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (change, error) {
+			var c change
+			err := row.Scan(&c.id, &c.itemID, &c.event, &c.payload)
+			return c, err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, c := range changes {
+			if relayErr = r.relay(ctx, c.id, c.itemID, c.event, c.payload); relayErr != nil {
+				// Commit whatever was relayed before the failure, the rest is retried on the next flush
+				break
+			}
+
+			if _, err = tx.Exec(ctx, "UPDATE item_outbox SET published_at = now() WHERE id = $1", c.id); err != nil {
+				return err
+			}
+
+			relayed++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return relayed, relayErr
+}
+
+// relay publishes a single change and applies it to the cache.
+func (r *Relay) relay(ctx context.Context, id int64, itemID, event string, payload []byte) error {
+	msg := &nats.Msg{
+		Subject: "items." + event + "." + itemID,
+		Data:    payload,
+	}
+
+	if _, err := r.js.PublishMsg(ctx, msg, jetstream.WithMsgID(strconv.FormatInt(id, 10))); err != nil {
+		return err
+	}
+
+	if event == EventItemDeleted {
+		if err := r.cache.Purge(ctx, itemID); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if _, err := r.cache.Put(ctx, itemID, payload); err != nil {
+		return err
+	}
+
+	return nil
+}
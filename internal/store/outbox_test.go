@@ -0,0 +1,280 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/internal/natstest"
+	"exampleapp/internal/store"
+)
+
+type outboxRow struct {
+	id        int64
+	itemID    string
+	event     string
+	published bool
+}
+
+// outboxRows returns every row in the outbox, oldest first.
+func outboxRows(t *testing.T, pool *pgxpool.Pool) []outboxRow {
+	t.Helper()
+
+	rows, err := pool.Query(context.Background(), "SELECT id, item_id::text, event_type, published_at IS NOT NULL FROM item_outbox ORDER BY id")
+	if err != nil {
+		t.Fatalf("unable to read outbox: %v", err)
+	}
+
+	outbox, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (outboxRow, error) {
+		var r outboxRow
+		err := row.Scan(&r.id, &r.itemID, &r.event, &r.published)
+		return r, err
+	})
+	if err != nil {
+		t.Fatalf("unable to read outbox: %v", err)
+	}
+
+	return outbox
+}
+
+// cachedItem returns the item cached under id, or nil when there is none.
+func cachedItem(t *testing.T, cache jetstream.KeyValue, id string) *store.Item {
+	t.Helper()
+
+	entry, err := cache.Get(context.Background(), id)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, jetstream.ErrKeyDeleted) {
+			return nil
+		}
+		t.Fatalf("unable to read cache: %v", err)
+	}
+
+	var item store.Item
+	if err = json.Unmarshal(entry.Value(), &item); err != nil {
+		t.Fatalf("unable to decode cached item: %v", err)
+	}
+
+	return &item
+}
+
+// mustFlush relays the outbox, failing unless want changes were relayed.
+func mustFlush(t *testing.T, relay *store.Relay, want int) {
+	t.Helper()
+
+	relayed, err := relay.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if relayed != want {
+		t.Fatalf("Flush relayed %d changes, want %d", relayed, want)
+	}
+}
+
+// TestRelay checks item changes are recorded in the outbox and relayed to the ITEMS stream and the cache. It needs the
+// disposable database named by APP_TEST_DATABASE_URI.
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	pool := testDB(t)
+	truncate(t, pool)
+
+	js := natstest.JetStream(t)
+	logger := slog.New(slog.DiscardHandler)
+
+	cache, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "cache", LimitMarkerTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("unable to create cache: %v", err)
+	}
+
+	items := store.NewItemStore(pool, cache, store.WithLogger(logger))
+
+	relay, err := store.NewRelay(ctx, pool, js, cache, logger)
+	if err != nil {
+		t.Fatalf("NewRelay: %v", err)
+	}
+
+	stream, err := js.Stream(ctx, store.OutboxStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// relayed checks the latest change relayed for the item was published with its outbox id as the message ID
+	relayed := func(row outboxRow) {
+		t.Helper()
+
+		if !row.published {
+			t.Fatalf("outbox row %d not marked published", row.id)
+		}
+
+		msg, err := stream.GetLastMsgForSubject(ctx, "items."+row.event+"."+row.itemID)
+		if err != nil {
+			t.Fatalf("no %s message for item %s: %v", row.event, row.itemID, err)
+		}
+		if got := msg.Header.Get(jetstream.MsgIDHeader); got != strconv.FormatInt(row.id, 10) {
+			t.Fatalf("message ID %q, want outbox id %d", got, row.id)
+		}
+	}
+
+	item := &store.Item{Name: "first"}
+	if err = items.Create(ctx, item); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	outbox := outboxRows(t, pool)
+	if len(outbox) != 1 || outbox[0].event != store.EventItemCreated || outbox[0].itemID != item.ID || outbox[0].published {
+		t.Fatalf("outbox after Create = %+v, want one unpublished created row", outbox)
+	}
+
+	mustFlush(t, relay, 1)
+	relayed(outboxRows(t, pool)[0])
+	if cached := cachedItem(t, cache, item.ID); cached == nil || cached.Name != "first" {
+		t.Fatalf("cached item after create = %+v", cached)
+	}
+
+	// Nothing left to relay
+	mustFlush(t, relay, 0)
+
+	item.Name = "second"
+	if err = items.Update(ctx, item.ID, item); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	outbox = outboxRows(t, pool)
+	if len(outbox) != 2 || outbox[1].event != store.EventItemUpdated || outbox[1].published {
+		t.Fatalf("outbox after Update = %+v, want an unpublished updated row", outbox)
+	}
+
+	mustFlush(t, relay, 1)
+	relayed(outboxRows(t, pool)[1])
+	if cached := cachedItem(t, cache, item.ID); cached == nil || cached.Name != "second" || cached.Version != 2 {
+		t.Fatalf("cached item after update = %+v", cached)
+	}
+
+	if err = items.Delete(ctx, item.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	outbox = outboxRows(t, pool)
+	if len(outbox) != 3 || outbox[2].event != store.EventItemDeleted || outbox[2].published {
+		t.Fatalf("outbox after Delete = %+v, want an unpublished deleted row", outbox)
+	}
+
+	mustFlush(t, relay, 1)
+	relayed(outboxRows(t, pool)[2])
+	if cached := cachedItem(t, cache, item.ID); cached != nil {
+		t.Fatalf("cached item after delete = %+v, want none", cached)
+	}
+}
+
+// TestRelayFailure checks a change that fails to relay is retried, holding back the changes after it.
+func TestRelayFailure(t *testing.T) {
+	ctx := context.Background()
+	pool := testDB(t)
+	truncate(t, pool)
+
+	js := natstest.JetStream(t)
+	logger := slog.New(slog.DiscardHandler)
+
+	// Too small for the second item, so caching it fails
+	cfg := jetstream.KeyValueConfig{Bucket: "cache", MaxValueSize: 180}
+	cache, err := js.CreateKeyValue(ctx, cfg)
+	if err != nil {
+		t.Fatalf("unable to create cache: %v", err)
+	}
+
+	items := store.NewItemStore(pool, cache, store.WithLogger(logger))
+	for _, name := range []string{"small", strings.Repeat("large ", 16), "after"} {
+		if err = items.Create(ctx, &store.Item{Name: strings.TrimSpace(name)}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	relay, err := store.NewRelay(ctx, pool, js, cache, logger)
+	if err != nil {
+		t.Fatalf("NewRelay: %v", err)
+	}
+
+	relayed, err := relay.Flush(ctx)
+	if err == nil || relayed != 1 {
+		t.Fatalf("Flush: got %d, %v, want 1 relayed and an error", relayed, err)
+	}
+
+	var published []bool
+	for _, row := range outboxRows(t, pool) {
+		published = append(published, row.published)
+	}
+	if want := []bool{true, false, false}; !slices.Equal(published, want) {
+		t.Fatalf("published rows %v, want %v", published, want)
+	}
+
+	// Once the cache accepts it, the rest is relayed and the repeated publish is dropped as a duplicate
+	cfg.MaxValueSize = 0
+	if _, err = js.UpdateKeyValue(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	mustFlush(t, relay, 2)
+
+	stream, err := js.Stream(ctx, store.OutboxStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != 3 {
+		t.Fatalf("ITEMS holds %d messages, want 3", info.State.Msgs)
+	}
+}
+
+// TestRelayPrune checks only changes published longer ago than the retention window are pruned.
+func TestRelayPrune(t *testing.T) {
+	ctx := context.Background()
+	pool := testDB(t)
+	truncate(t, pool)
+
+	js := natstest.JetStream(t)
+
+	cache, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "cache"})
+	if err != nil {
+		t.Fatalf("unable to create cache: %v", err)
+	}
+
+	relay, err := store.NewRelay(ctx, pool, js, cache, slog.New(slog.DiscardHandler), store.WithRelayRetention(time.Hour))
+	if err != nil {
+		t.Fatalf("NewRelay: %v", err)
+	}
+
+	// Old and recent published changes, and an old one still waiting to be published
+	if _, err = pool.Exec(ctx, `
+		INSERT INTO item_outbox (item_id, event_type, payload, created_at, published_at) VALUES
+			(gen_random_uuid(), 'created', '{}', now() - interval '3 hours', now() - interval '2 hours'),
+			(gen_random_uuid(), 'created', '{}', now() - interval '2 minutes', now() - interval '1 minute'),
+			(gen_random_uuid(), 'created', '{}', now() - interval '3 hours', NULL)
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := relay.Prune(ctx)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if pruned != 1 {
+		t.Fatalf("Prune deleted %d rows, want 1", pruned)
+	}
+
+	outbox := outboxRows(t, pool)
+	if len(outbox) != 2 || !outbox[0].published || outbox[1].published {
+		t.Fatalf("outbox after Prune = %+v, want the recent published row and the unpublished one", outbox)
+	}
+}
@@ -2,100 +2,438 @@ package store
 
 import (
 	"context"
-	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/sync/singleflight"
+
+	"exampleapp/internal/validator"
 )
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound      = errors.New("not found")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrConflict      = errors.New("item modified concurrently")
+)
+
+const (
+	defaultNegativeTTL = 30 * time.Second
+	defaultLoadTimeout = 5 * time.Second
+
+	defaultListLimit = 25
+	maxListLimit     = 100
+
+	maxNameLength = 100
 )
 
 type Item struct {
-	ID   string `json:"id" db:"id"`
-	Name string `json:"name" db:"name"`
+	ID        string    `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	Version   int       `json:"version" db:"version"` // incremented by every update, starting at 1
+}
+
+// ListParams filters and pages ItemStore.List.
+type ListParams struct {
+	NamePrefix string // only list items whose name starts with this
+	Cursor     string // opaque cursor from a previous page, empty for the first page
+	Limit      int    // page size, defaults to 25 and is capped at 100
+}
+
+// ValidationError is returned by Create and Update for items failing Item.Validate, holding a message per field.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	fields := slices.Sorted(maps.Keys(e.Fields))
+
+	problems := make([]string, 0, len(fields))
+	for _, field := range fields {
+		problems = append(problems, field+": "+e.Fields[field])
+	}
+
+	return "invalid item: " + strings.Join(problems, ", ")
+}
+
+// itemNameRx lists the characters allowed in item names.
+var itemNameRx = regexp.MustCompile(`^[\p{L}\p{N} _.,'&()-]*$`)
+
+// Validate checks the user editable fields of an item.
+func (i *Item) Validate() *validator.Validator {
+	v := validator.New()
+
+	v.Check(strings.TrimSpace(i.Name) != "", "name", "must be provided")
+	v.Check(i.Name == strings.TrimSpace(i.Name), "name", "must not start or end with spaces")
+	v.Check(utf8.RuneCountInString(i.Name) <= maxNameLength, "name", fmt.Sprintf("must not be more than %d characters long", maxNameLength))
+	v.Check(validator.Matches(i.Name, itemNameRx), "name", "must only contain letters, numbers, spaces and _ . , ' & ( ) -")
+
+	return v
 }
 
-func (i *Item) Validate() {
+// validate returns a ValidationError when item is invalid.
+func validate(item *Item) error {
+	if v := item.Validate(); !v.Valid() {
+		return &ValidationError{Fields: v.Errors}
+	}
+
+	return nil
+}
 
+// Items is the set of item operations handlers rely on, implemented by the Postgres ItemStore and the event-sourced
+// EventItemStore.
+type Items interface {
+	Create(ctx context.Context, item *Item) error
+	Get(ctx context.Context, id string) (*Item, error)
+	Update(ctx context.Context, id string, item *Item) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context, params ListParams) ([]*Item, string, error)
+	Count(ctx context.Context, namePrefix string) (int, error)
+	History(ctx context.Context, id string) ([]*Revision, error)
 }
 
+var (
+	_ Items = (*ItemStore)(nil)
+	_ Items = (*EventItemStore)(nil)
+)
+
 type ItemStore struct {
-	db    *pgxpool.Pool
-	cache jetstream.KeyValue
+	db          *pgxpool.Pool
+	cache       jetstream.KeyValue
+	negativeTTL time.Duration
+	loadTimeout time.Duration
+	loads       singleflight.Group
+	logger      *slog.Logger
 }
 
-func NewItemStore(db *pgxpool.Pool, cache jetstream.KeyValue) *ItemStore {
-	return &ItemStore{
-		db:    db,
-		cache: cache,
+type Option func(*ItemStore)
+
+// WithNegativeTTL sets how long an id is cached as not found, defaults to 30 seconds.
+// Negative entries require the cache bucket to allow per-message TTLs (LimitMarkerTTL).
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(store *ItemStore) {
+		store.negativeTTL = ttl
 	}
 }
 
-// TODO: Maybe implement event sourcing as the canonical store, but for now - just be lazy
+// WithLoadTimeout bounds loading an item missing from the cache, defaults to 5 seconds. The load is shared by
+// concurrent callers, so it isn't bound by any one caller's context.
+func WithLoadTimeout(timeout time.Duration) Option {
+	return func(store *ItemStore) {
+		store.loadTimeout = timeout
+	}
+}
 
-func (s *ItemStore) Create(ctx context.Context, item *Item) error {
-	if err := s.db.QueryRow(ctx, "INSERT INTO items (name) VALUES ($1) RETURNING id", item.Name).Scan(&item.ID); err != nil {
-		return err
+// WithLogger sets the logger cache write failures are reported to, by default a text logger on stdout.
+func WithLogger(logger *slog.Logger) Option {
+	return func(store *ItemStore) {
+		store.logger = logger
+	}
+}
+
+func NewItemStore(db *pgxpool.Pool, cache jetstream.KeyValue, opts ...Option) *ItemStore {
+	store := &ItemStore{
+		db:          db,
+		cache:       cache,
+		negativeTTL: defaultNegativeTTL,
+		loadTimeout: defaultLoadTimeout,
+		logger:      slog.New(slog.NewTextHandler(os.Stdout, nil)),
 	}
 
-	// Add cache entry
-	itemJson, err := json.Marshal(item)
-	if err != nil {
-		return err
+	for _, opt := range opts {
+		opt(store)
 	}
 
-	if _, err = s.cache.Create(ctx, item.ID, itemJson); err != nil {
+	return store
+}
+
+// Create inserts an item, recording an item.created event in the outbox and a revision in its history in the same
+// transaction. The cache is populated by the outbox relay, or on first read.
+func (s *ItemStore) Create(ctx context.Context, item *Item) error {
+	if err := validate(item); err != nil {
 		return err
 	}
 
-	return nil
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, "INSERT INTO items (name) VALUES ($1) RETURNING id, created_at, version", item.Name).
+			Scan(&item.ID, &item.CreatedAt, &item.Version); err != nil {
+			return err
+		}
+
+		if err := writeOutbox(ctx, tx, EventItemCreated, item); err != nil {
+			return err
+		}
+
+		return writeRevision(ctx, tx, EventItemCreated, nil, item)
+	})
 }
 
+// Get returns an item, reading through the cache. Misses are loaded from the database and written back to the cache,
+// items that don't exist are cached as not found for the negative TTL, and concurrent misses for the same id share a
+// single database query.
 func (s *ItemStore) Get(ctx context.Context, id string) (*Item, error) {
-	cItem, err := s.cache.Get(ctx, id)
-	if err != nil {
-		if !errors.Is(err, jetstream.ErrKeyNotFound) || !errors.Is(err, jetstream.ErrKeyDeleted) {
-			return nil, err
+	entry, err := s.cache.Get(ctx, id)
+	switch {
+	case err == nil:
+		if len(entry.Value()) == 0 {
+			return nil, ErrNotFound
 		}
 
-		// Cache miss, head to the database
 		var item Item
-		if err = s.db.QueryRow(ctx, "SELECT id, name FROM items WHERE id = $1", id).
-			Scan(&item.ID, &item.Name); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return nil, ErrNotFound
-			}
+		if err = json.Unmarshal(entry.Value(), &item); err == nil {
+			return &item, nil
+		}
+
+		// Unreadable cache entry, delete it unless replaced meanwhile and fall through to refill it from the database
+		if err = s.cache.Delete(ctx, id, jetstream.LastRevision(entry.Revision())); err != nil && !isWrongLastSequence(err) {
+			s.logger.Warn("unable to delete unreadable cached item", slog.String("id", id), slog.String("error", err.Error()))
 		}
+	case errors.Is(err, jetstream.ErrInvalidKey):
+		// Not a valid key, so it can't be a valid item id either
+		return nil, ErrNotFound
+	case !errors.Is(err, jetstream.ErrKeyNotFound) && !errors.Is(err, jetstream.ErrKeyDeleted):
+		return nil, err
+	}
+
+	// The shared load outlives any one caller's context, each caller still stops waiting when its own is done
+	result := s.loads.DoChan(id, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.loadTimeout)
+		defer cancel()
+
+		return s.load(ctx, id)
+	})
 
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+
+		// Callers sharing a load each get their own copy
+		item := *res.Val.(*Item)
 		return &item, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
+
+// load reads an item from the database and populates the cache with the result.
+// The cache is filled with Create rather than Put, so a newer value cached by the relay since the query isn't
+// overwritten with the one read. Cache writes are best effort, failures are logged and the database remains the source
+// of truth.
+func (s *ItemStore) load(ctx context.Context, id string) (*Item, error) {
+	var item Item
 
-	var item *Item
-	if err = json.Unmarshal(cItem.Value(), item); err != nil {
+	if err := s.db.QueryRow(ctx, "SELECT id, name, created_at, version FROM items WHERE id = $1", id).
+		Scan(&item.ID, &item.Name, &item.CreatedAt, &item.Version); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) || isInvalidText(err) {
+			if _, err = s.cache.Create(ctx, id, nil, jetstream.KeyTTL(s.negativeTTL)); err != nil && !errors.Is(err, jetstream.ErrKeyExists) {
+				s.logger.Warn("unable to cache item as not found", slog.String("id", id), slog.String("error", err.Error()))
+			}
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
-	return item, nil
+	itemJson, err := json.Marshal(&item)
+	if err == nil {
+		_, err = s.cache.Create(ctx, id, itemJson)
+	}
+	if err != nil && !errors.Is(err, jetstream.ErrKeyExists) {
+		s.logger.Warn("unable to cache item", slog.String("id", id), slog.String("error", err.Error()))
+	}
+
+	return &item, nil
+}
+
+// isWrongLastSequence reports whether err is JetStream rejecting a write because the key or subject has moved on.
+func isWrongLastSequence(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
+}
+
+// isInvalidText reports whether err is Postgres rejecting a malformed value, such as an id that isn't a UUID.
+func isInvalidText(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "22P02" // invalid_text_representation
 }
 
+// Update renames an item, recording an item.updated event in the outbox and a revision in its history in the same
+// transaction.
+// item.Version must be the version the caller last read, otherwise the update fails with ErrConflict. On success item
+// is filled in with the stored values, including the new version.
 func (s *ItemStore) Update(ctx context.Context, id string, item *Item) error {
-	if err := s.db.QueryRow(ctx, "UPDATE items SET name = $1 WHERE id = $2 RETURNING id", item.Name, id).Scan(&item.ID); err != nil {
+	if err := validate(item); err != nil {
 		return err
 	}
 
-	// Update cache
-	itemJson, err := json.Marshal(item)
-	if err != nil {
+	if err := pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		// Lock the row so the version check and the history's before values hold until commit
+		var before Item
+		if err := tx.QueryRow(ctx, "SELECT id, name, created_at, version FROM items WHERE id = $1 FOR UPDATE", id).
+			Scan(&before.ID, &before.Name, &before.CreatedAt, &before.Version); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) || isInvalidText(err) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if before.Version != item.Version {
+			return ErrConflict
+		}
+
+		if err := tx.QueryRow(ctx, "UPDATE items SET name = $1, version = version + 1 WHERE id = $2 RETURNING id, created_at, version", item.Name, id).
+			Scan(&item.ID, &item.CreatedAt, &item.Version); err != nil {
+			return err
+		}
+
+		if err := writeOutbox(ctx, tx, EventItemUpdated, item); err != nil {
+			return err
+		}
+
+		return writeRevision(ctx, tx, EventItemUpdated, &before, item)
+	}); err != nil {
 		return err
 	}
 
-	if _, err = s.cache.Put(ctx, id, itemJson); err != nil {
+	s.invalidate(ctx, id)
+
+	return nil
+}
+
+// Delete removes an item, recording an item.deleted event in the outbox and a revision in its history in the same
+// transaction.
+func (s *ItemStore) Delete(ctx context.Context, id string) error {
+	if err := pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		var item Item
+		if err := tx.QueryRow(ctx, "DELETE FROM items WHERE id = $1 RETURNING id, name, created_at, version", id).
+			Scan(&item.ID, &item.Name, &item.CreatedAt, &item.Version); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) || isInvalidText(err) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if err := writeOutbox(ctx, tx, EventItemDeleted, &item); err != nil {
+			return err
+		}
+
+		return writeRevision(ctx, tx, EventItemDeleted, &item, nil)
+	}); err != nil {
 		return err
 	}
 
+	s.invalidate(ctx, id)
+
 	return nil
 }
+
+// invalidate drops the cached copy of an item so readers go to the database until the outbox relay catches up.
+// This is best effort, the relay updates the cache either way.
+func (s *ItemStore) invalidate(ctx context.Context, id string) {
+	_ = s.cache.Purge(ctx, id)
+}
+
+// List returns a page of items in creation order and the cursor for the next page, empty when there are no more.
+func (s *ItemStore) List(ctx context.Context, params ListParams) ([]*Item, string, error) {
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	query := "SELECT id, name, created_at, version FROM items WHERE starts_with(name, $1)"
+	args := []any{params.NamePrefix}
+
+	if params.Cursor != "" {
+		afterCreated, afterID, err := decodeCursor(params.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		query += " AND (created_at, id) > ($2, $3)"
+		args = append(args, afterCreated, afterID)
+	}
+
+	// Fetch one extra row to learn whether there is a next page
+	query += fmt.Sprintf(" ORDER BY created_at, id LIMIT $%d", len(args)+1)
+	args = append(args, limit+1)
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	items := make([]*Item, 0, limit)
+
+	for rows.Next() {
+		var item Item
+		if err = rows.Scan(&item.ID, &item.Name, &item.CreatedAt, &item.Version); err != nil {
+			return nil, "", err
+		}
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(items) <= limit {
+		return items, "", nil
+	}
+
+	items = items[:limit]
+	last := items[limit-1]
+
+	return items, encodeCursor(last.CreatedAt, last.ID), nil
+}
+
+// Count returns the number of items whose name starts with namePrefix, or all items when it is empty.
+func (s *ItemStore) Count(ctx context.Context, namePrefix string) (int, error) {
+	var count int
+
+	if err := s.db.QueryRow(ctx, "SELECT count(*) FROM items WHERE starts_with(name, $1)", namePrefix).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// encodeCursor builds an opaque keyset cursor pointing after the item with the given creation time and id.
+func encodeCursor(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	createdAt, id, ok := strings.Cut(string(b), "|")
+	if !ok || !uuidRx.MatchString(id) {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	return t, id, nil
+}
@@ -0,0 +1,189 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/db"
+	"exampleapp/internal/migrate"
+	"exampleapp/internal/natstest"
+	"exampleapp/internal/store"
+	"exampleapp/internal/store/storetest"
+)
+
+// testDB opens and migrates the disposable database named by the APP_TEST_DATABASE_URI connection string, skipping
+// the test when it isn't set.
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	dsn := os.Getenv("APP_TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("APP_TEST_DATABASE_URI not set")
+	}
+
+	ctx := context.Background()
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	migrations, err := fs.Sub(db.Migrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.New(pool, migrations, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatalf("unable to migrate database: %v", err)
+	}
+
+	return pool
+}
+
+// truncate deletes every item and its history from the database.
+func truncate(t *testing.T, pool *pgxpool.Pool) {
+	t.Helper()
+
+	if _, err := pool.Exec(context.Background(), "TRUNCATE items, item_outbox, item_revisions"); err != nil {
+		t.Fatalf("unable to truncate items: %v", err)
+	}
+}
+
+// TestItemStore runs the conformance suite against Postgres. It needs a disposable database, whose items are deleted
+// between subtests.
+func TestItemStore(t *testing.T) {
+	ctx := context.Background()
+	pool := testDB(t)
+	js := natstest.JetStream(t)
+	buckets := 0
+
+	storetest.Run(t, func(t *testing.T) store.Items {
+		truncate(t, pool)
+
+		// A fresh cache per subtest, so nothing cached survives the truncate
+		buckets++
+		cache, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:         fmt.Sprintf("cache-%d", buckets),
+			LimitMarkerTTL: time.Minute,
+		})
+		if err != nil {
+			t.Fatalf("unable to create cache: %v", err)
+		}
+
+		return store.NewItemStore(pool, cache)
+	})
+}
+
+// TestEventItemStore runs the conformance suite against the event log, with a projector keeping the Postgres and
+// cache projections up to date. Every subtest gets its own NATS server, so its log starts empty.
+func TestEventItemStore(t *testing.T) {
+	pool := testDB(t)
+
+	storetest.Run(t, func(t *testing.T) store.Items {
+		truncate(t, pool)
+
+		ctx := context.Background()
+		js := natstest.JetStream(t)
+
+		cache, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "cache", LimitMarkerTTL: time.Minute})
+		if err != nil {
+			t.Fatalf("unable to create cache: %v", err)
+		}
+
+		items, err := store.NewEventItemStore(ctx, js, store.NewItemStore(pool, cache))
+		if err != nil {
+			t.Fatalf("unable to create store: %v", err)
+		}
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- store.NewProjector(pool, js, cache, slog.New(slog.DiscardHandler)).Run(runCtx)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("Run: %v", err)
+			}
+		})
+
+		return &projected{EventItemStore: items, js: js}
+	})
+}
+
+// projected waits for the projections to catch up after every write, so the suite can list what it just wrote.
+type projected struct {
+	*store.EventItemStore
+	js jetstream.JetStream
+}
+
+func (p *projected) Create(ctx context.Context, item *store.Item) error {
+	if err := p.EventItemStore.Create(ctx, item); err != nil {
+		return err
+	}
+
+	return p.sync(ctx)
+}
+
+func (p *projected) Update(ctx context.Context, id string, item *store.Item) error {
+	if err := p.EventItemStore.Update(ctx, id, item); err != nil {
+		return err
+	}
+
+	return p.sync(ctx)
+}
+
+func (p *projected) Delete(ctx context.Context, id string) error {
+	if err := p.EventItemStore.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	return p.sync(ctx)
+}
+
+// sync waits until the projector has acknowledged every event in the log.
+func (p *projected) sync(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	stream, err := p.js.Stream(ctx, store.EventStream)
+	if err != nil {
+		return err
+	}
+
+	for {
+		info, err := stream.Info(ctx)
+		if err != nil {
+			return err
+		}
+
+		// The consumer is created once the projector starts
+		consumer, err := p.js.Consumer(ctx, store.EventStream, "items-projection")
+		if err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+			return err
+		}
+		if err == nil && consumer.CachedInfo().AckFloor.Stream >= info.State.LastSeq {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for the projection: %w", ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,398 @@
+// Package storetest provides a conformance suite that every store.Items implementation must pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"exampleapp/internal/store"
+)
+
+// Run runs the conformance suite, calling newItems for an empty store at the start of every subtest.
+func Run(t *testing.T, newItems func(t *testing.T) store.Items) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, items store.Items)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"CreateInvalid", testCreateInvalid},
+		{"GetNotFound", testGetNotFound},
+		{"Update", testUpdate},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"UpdateConflict", testUpdateConflict},
+		{"UpdateInvalid", testUpdateInvalid},
+		{"Delete", testDelete},
+		{"DeleteNotFound", testDeleteNotFound},
+		{"ListPages", testListPages},
+		{"ListNamePrefix", testListNamePrefix},
+		{"ListInvalidCursor", testListInvalidCursor},
+		{"Count", testCount},
+		{"History", testHistory},
+		{"HistoryNotFound", testHistoryNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newItems(t))
+		})
+	}
+}
+
+// missingID is a well formed id that no store will have generated.
+const missingID = "00000000-0000-4000-8000-000000000000"
+
+func create(t *testing.T, items store.Items, name string) *store.Item {
+	t.Helper()
+
+	item := &store.Item{Name: name}
+	if err := items.Create(context.Background(), item); err != nil {
+		t.Fatalf("Create(%q): %v", name, err)
+	}
+
+	return item
+}
+
+func mustGet(t *testing.T, items store.Items, id string) *store.Item {
+	t.Helper()
+
+	item, err := items.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", id, err)
+	}
+
+	return item
+}
+
+func sameItem(a, b *store.Item) bool {
+	return a.ID == b.ID && a.Name == b.Name && a.CreatedAt.Equal(b.CreatedAt) && a.Version == b.Version
+}
+
+func testCreateAndGet(t *testing.T, items store.Items) {
+	item := create(t, items, "widget")
+
+	if item.ID == "" {
+		t.Fatal("Create did not set ID")
+	}
+	if item.CreatedAt.IsZero() {
+		t.Fatal("Create did not set CreatedAt")
+	}
+	if item.Version != 1 {
+		t.Fatalf("Create set Version %d, want 1", item.Version)
+	}
+
+	if got := mustGet(t, items, item.ID); !sameItem(got, item) {
+		t.Fatalf("Get = %+v, want %+v", got, item)
+	}
+
+	if other := create(t, items, "widget"); other.ID == item.ID {
+		t.Fatalf("Create reused id %q", item.ID)
+	}
+}
+
+// invalidNames are names every store must refuse.
+var invalidNames = []string{"", "   ", " padded", "padded ", "semi;colon", "<script>", strings.Repeat("x", 101)}
+
+// mustBeInvalid fails unless err is a ValidationError with a message for field.
+func mustBeInvalid(t *testing.T, err error, field string) {
+	t.Helper()
+
+	var verr *store.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("error = %v, want ValidationError", err)
+	}
+	if verr.Fields[field] == "" {
+		t.Fatalf("ValidationError %v has no message for %q", verr.Fields, field)
+	}
+}
+
+func testCreateInvalid(t *testing.T, items store.Items) {
+	for _, name := range invalidNames {
+		err := items.Create(context.Background(), &store.Item{Name: name})
+		mustBeInvalid(t, err, "name")
+	}
+
+	if count, err := items.Count(context.Background(), ""); err != nil || count != 0 {
+		t.Fatalf("Count after invalid creates = %d, %v, want 0", count, err)
+	}
+
+	create(t, items, "Tea & biscuits (2), Earl-Grey_x.'s")
+	create(t, items, strings.Repeat("é", 100))
+}
+
+func testGetNotFound(t *testing.T, items store.Items) {
+	for _, id := range []string{missingID, "not-a-uuid", ""} {
+		if _, err := items.Get(context.Background(), id); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get(%q) error = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func testUpdate(t *testing.T, items store.Items) {
+	item := create(t, items, "before")
+
+	update := &store.Item{Name: "after", Version: item.Version}
+	if err := items.Update(context.Background(), item.ID, update); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	want := &store.Item{ID: item.ID, Name: "after", CreatedAt: item.CreatedAt, Version: item.Version + 1}
+
+	if !sameItem(update, want) {
+		t.Fatalf("Update filled in %+v, want %+v", update, want)
+	}
+	if got := mustGet(t, items, item.ID); !sameItem(got, want) {
+		t.Fatalf("Get after Update = %+v, want %+v", got, want)
+	}
+}
+
+func testUpdateNotFound(t *testing.T, items store.Items) {
+	for _, id := range []string{missingID, "not-a-uuid"} {
+		if err := items.Update(context.Background(), id, &store.Item{Name: "x", Version: 1}); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Update(%q) error = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func testUpdateConflict(t *testing.T, items store.Items) {
+	item := create(t, items, "original")
+
+	// Two editors read the same version, the second to save loses
+	first := &store.Item{Name: "first", Version: item.Version}
+	if err := items.Update(context.Background(), item.ID, first); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	second := &store.Item{Name: "second", Version: item.Version}
+	if err := items.Update(context.Background(), item.ID, second); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Update with stale version error = %v, want ErrConflict", err)
+	}
+
+	if got := mustGet(t, items, item.ID); !sameItem(got, first) {
+		t.Fatalf("Get after conflict = %+v, want %+v", got, first)
+	}
+}
+
+func testUpdateInvalid(t *testing.T, items store.Items) {
+	item := create(t, items, "valid")
+
+	for _, name := range invalidNames {
+		err := items.Update(context.Background(), item.ID, &store.Item{Name: name, Version: item.Version})
+		mustBeInvalid(t, err, "name")
+	}
+
+	if got := mustGet(t, items, item.ID); !sameItem(got, item) {
+		t.Fatalf("Get after invalid updates = %+v, want %+v", got, item)
+	}
+}
+
+func testDelete(t *testing.T, items store.Items) {
+	item := create(t, items, "doomed")
+	kept := create(t, items, "kept")
+
+	if err := items.Delete(context.Background(), item.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err := items.Get(context.Background(), item.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+	mustGet(t, items, kept.ID)
+}
+
+func testDeleteNotFound(t *testing.T, items store.Items) {
+	for _, id := range []string{missingID, "not-a-uuid"} {
+		if err := items.Delete(context.Background(), id); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Delete(%q) error = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func testListPages(t *testing.T, items store.Items) {
+	var created []*store.Item
+	for i := range 5 {
+		created = append(created, create(t, items, fmt.Sprintf("item %d", i)))
+	}
+
+	var (
+		listed []*store.Item
+		cursor string
+		pages  int
+	)
+
+	for {
+		page, next, err := items.List(context.Background(), store.ListParams{Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatalf("List(cursor %q): %v", cursor, err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("List returned %d items, want at most 2", len(page))
+		}
+
+		listed = append(listed, page...)
+		pages++
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if pages != 3 {
+		t.Errorf("listed %d pages, want 3", pages)
+	}
+	if len(listed) != len(created) {
+		t.Fatalf("listed %d items, want %d", len(listed), len(created))
+	}
+
+	// Items are listed in creation order, those created in the same instant by id
+	for i := 1; i < len(listed); i++ {
+		a, b := listed[i-1], listed[i]
+		if a.CreatedAt.After(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.ID >= b.ID) {
+			t.Fatalf("listed %+v before %+v", a, b)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range listed {
+		seen[item.ID] = true
+	}
+	for _, item := range created {
+		if !seen[item.ID] {
+			t.Errorf("item %+v not listed", item)
+		}
+	}
+}
+
+func testListNamePrefix(t *testing.T, items store.Items) {
+	create(t, items, "apple")
+	create(t, items, "apricot")
+	create(t, items, "banana")
+	create(t, items, "Apple") // prefixes are case sensitive
+
+	page, next, err := items.List(context.Background(), store.ListParams{NamePrefix: "ap"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if next != "" {
+		t.Errorf("List next cursor = %q, want none", next)
+	}
+
+	if len(page) != 2 {
+		t.Fatalf("List(prefix ap) returned %d items, want 2", len(page))
+	}
+	for _, item := range page {
+		if item.Name != "apple" && item.Name != "apricot" {
+			t.Errorf("List(prefix ap) returned %q", item.Name)
+		}
+	}
+}
+
+func testListInvalidCursor(t *testing.T, items store.Items) {
+	for _, cursor := range []string{"!!!", "bm9waXBl", "MjAyNi0wMS0wMVQwMDowMDowMFp8bm90LWEtdXVpZA"} {
+		if _, _, err := items.List(context.Background(), store.ListParams{Cursor: cursor}); !errors.Is(err, store.ErrInvalidCursor) {
+			t.Errorf("List(cursor %q) error = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func testCount(t *testing.T, items store.Items) {
+	create(t, items, "apple")
+	create(t, items, "apricot")
+	create(t, items, "banana")
+
+	for prefix, want := range map[string]int{"": 3, "ap": 2, "b": 1, "cherry": 0} {
+		got, err := items.Count(context.Background(), prefix)
+		if err != nil {
+			t.Fatalf("Count(%q): %v", prefix, err)
+		}
+		if got != want {
+			t.Errorf("Count(%q) = %d, want %d", prefix, got, want)
+		}
+	}
+}
+
+func testHistory(t *testing.T, items store.Items) {
+	ctx := store.WithActor(context.Background(), "alice")
+
+	item := &store.Item{Name: "first"}
+	if err := items.Create(ctx, item); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	created := *item
+
+	if err := items.Update(store.WithActor(ctx, "bob"), item.ID, item); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	renamed := *item
+
+	item.Name = "second"
+	if err := items.Update(store.WithActor(ctx, "bob"), item.ID, item); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	updated := *item
+
+	if err := items.Delete(context.Background(), item.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	// Other items' changes stay out of this item's history
+	create(t, items, "unrelated")
+
+	revisions, err := items.History(context.Background(), item.ID)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(revisions) != 4 {
+		t.Fatalf("History returned %d revisions, want 4", len(revisions))
+	}
+
+	want := []struct {
+		action, actor string
+		before, after *store.Item
+	}{
+		{store.EventItemCreated, "alice", nil, &created},
+		{store.EventItemUpdated, "bob", &created, &renamed},
+		{store.EventItemUpdated, "bob", &renamed, &updated},
+		{store.EventItemDeleted, "", &updated, nil},
+	}
+
+	for i, revision := range revisions {
+		w := want[i]
+
+		if revision.ItemID != item.ID || revision.Action != w.action || revision.Actor != w.actor {
+			t.Errorf("revision %d = %s %s by %q, want %s %s by %q", i, revision.ItemID, revision.Action, revision.Actor, item.ID, w.action, w.actor)
+		}
+		if revision.At.IsZero() {
+			t.Errorf("revision %d has no time", i)
+		}
+		if i > 0 && revision.ID <= revisions[i-1].ID {
+			t.Errorf("revision %d ID %d does not follow %d", i, revision.ID, revisions[i-1].ID)
+		}
+		if !sameRevisionItem(revision.Before, w.before) {
+			t.Errorf("revision %d before = %+v, want %+v", i, revision.Before, w.before)
+		}
+		if !sameRevisionItem(revision.After, w.after) {
+			t.Errorf("revision %d after = %+v, want %+v", i, revision.After, w.after)
+		}
+	}
+}
+
+// sameRevisionItem compares one side of a revision, which is nil for creations and deletions.
+func sameRevisionItem(a, b *store.Item) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return sameItem(a, b)
+}
+
+func testHistoryNotFound(t *testing.T, items store.Items) {
+	for _, id := range []string{missingID, "not-a-uuid"} {
+		if _, err := items.History(context.Background(), id); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("History(%q) error = %v, want ErrNotFound", id, err)
+		}
+	}
+}
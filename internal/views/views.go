@@ -1,8 +1,15 @@
 package views
 
 import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
 	. "github.com/derekmwright/htemel"
 	. "github.com/derekmwright/htemel/html"
+
+	"exampleapp/internal/store"
 )
 
 // These views use my own HTML package, you can easily swap this out for your own preferred package.
@@ -21,7 +28,7 @@ func Site(targetURL string) Node {
 				Script().Src("https://cdn.jsdelivr.net/npm/@tailwindcss/browser@4"),
 			),
 			Body(
-				Div().Id("app-view").Data("on-load", "@get('"+targetURL+"')"),
+				Div().Id("app-view").Data("on-load", "@get("+jsString(targetURL)+")"),
 			).Class("text-gray-200"),
 		).Id("page-root").Lang("en").Class("h-dvh bg-gray-900"),
 	)
@@ -43,11 +50,126 @@ func UserProfile() Node {
 	).Id("app-view")
 }
 
+// ItemPage shows a single item with a form to rename it. The version read here is sent back with the new name so
+// the rename is refused if someone else changed the item in the meantime.
+func ItemPage(item *store.Item) Node {
+	signals, _ := json.Marshal(map[string]any{"name": item.Name, "version": item.Version})
+
+	return Div(
+		SiteNav("item"),
+		H1(Text(item.Name)).Class("text-xl font-semibold"),
+		P(Text("ID: "+item.ID)).Class("text-sm text-gray-400"),
+		A(Text("History")).
+			Href("/items/"+item.ID+"/history").
+			Class("text-sm hover:text-gray-300").
+			Data("on-click__prevent", "@get('/items/"+item.ID+"/history')"),
+		Div().Id("item-notice"),
+		Form(
+			Input().Type("text").Data("bind", "name").Class("rounded bg-gray-800 px-2 py-1"),
+			Button(Text("Save")).Type("submit").Class("ml-2 hover:text-gray-300"),
+			ItemFieldError("name", ""),
+		).
+			Data("signals", string(signals)).
+			Data("on-submit__prevent", "@put('/items/"+item.ID+"')"),
+	).Id("app-view")
+}
+
+// ItemHistoryPage shows the timeline of changes made to an item, oldest first
+func ItemHistoryPage(id string, revisions []*store.Revision) Node {
+	entries := make([]Node, 0, len(revisions))
+	for _, revision := range revisions {
+		actor := revision.Actor
+		if actor == "" {
+			actor = "system"
+		}
+
+		entries = append(entries, Li(
+			Time(Text(revision.At.Format("2006-01-02 15:04:05 MST"))).
+				Datetime(revision.At.Format(time.RFC3339)).
+				Class("text-sm text-gray-400"),
+			Span(Text(" "+revisionSummary(revision)+" by "+actor)),
+		).Id("revision-"+strconv.FormatInt(revision.ID, 10)))
+	}
+
+	return Div(
+		SiteNav("items"),
+		H1(Text("Item history")).Class("text-xl font-semibold"),
+		A(Text("Back to item")).
+			Href("/items/"+id).
+			Class("text-sm hover:text-gray-300").
+			Data("on-click__prevent", "@get('/items/"+id+"')"),
+		Ol(entries...),
+	).Id("app-view")
+}
+
+// revisionSummary describes what a revision changed
+func revisionSummary(revision *store.Revision) string {
+	switch revision.Action {
+	case store.EventItemCreated:
+		return "Created as " + strconv.Quote(revision.After.Name)
+	case store.EventItemDeleted:
+		return "Deleted " + strconv.Quote(revision.Before.Name)
+	default:
+		return "Renamed from " + strconv.Quote(revision.Before.Name) + " to " + strconv.Quote(revision.After.Name)
+	}
+}
+
+// ItemFieldError shows the validation message for an item form field, an empty message clears it
+func ItemFieldError(field, message string) Node {
+	return P(Text(message)).Id("item-" + field + "-error").Class("text-sm text-red-400")
+}
+
+// ItemConflict replaces the item notice when a rename lost to someone else's change, offering to reload the item
+func ItemConflict(current *store.Item) Node {
+	return Div(
+		P(Text("This item was changed by someone else, it is now named \""+current.Name+"\".")),
+		A(Text("Reload")).
+			Href("/items/"+current.ID).
+			Class("underline hover:text-gray-300").
+			Data("on-click__prevent", "@get('/items/"+current.ID+"')"),
+	).Id("item-notice").Class("text-sm text-yellow-400")
+}
+
+// ItemsPage lists a page of items, linking to the next page when there is one
+func ItemsPage(items []*store.Item, total int, namePrefix, next string) Node {
+	rows := make([]Node, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, Li(
+			A(Text(item.Name)).
+				Href("/items/"+item.ID).
+				Class("hover:text-gray-300").
+				Data("on-click__prevent", "@get('/items/"+item.ID+"')"),
+			Button(Text("Delete")).
+				Class("ml-2 text-sm text-red-400 hover:text-red-300").
+				Data("on-click", "@delete('/items/"+item.ID+"')"),
+		).Id("item-"+item.ID))
+	}
+
+	pager := Group()
+	if next != "" {
+		nextURL := ItemsURL(namePrefix, next)
+
+		pager = A(Text("Next page")).
+			Href(nextURL).
+			Class("hover:text-gray-300").
+			Data("on-click__prevent", "@get("+jsString(nextURL)+")")
+	}
+
+	return Div(
+		SiteNav("items"),
+		H1(Text("Items")).Class("text-xl font-semibold"),
+		P(Text(strconv.Itoa(total)+" items")).Class("text-sm text-gray-400"),
+		Ul(rows...),
+		pager,
+	).Id("app-view")
+}
+
 func SiteNav(activeUrl string) Node {
 	return Div(
 		Nav(
 			Ul(
 				NavLink("Home", "/landing-page", activeUrl == "landing-page"),
+				NavLink("Items", "/items", activeUrl == "items"),
 				NavLink("User Profile", "/user/profile", activeUrl == "user-profile"),
 			),
 		),
@@ -69,3 +191,26 @@ func NavLink(name, url string, active bool) Node {
 			Data("on-click__prevent", "@get('"+url+"')"),
 	)
 }
+
+// ItemsURL returns the URL of the items page filtered by namePrefix and starting at cursor, either may be empty.
+func ItemsURL(namePrefix, cursor string) string {
+	query := url.Values{}
+	if namePrefix != "" {
+		query.Set("q", namePrefix)
+	}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	if len(query) == 0 {
+		return "/items"
+	}
+
+	return "/items?" + query.Encode()
+}
+
+// jsString quotes s as a JavaScript string literal for datastar expressions.
+func jsString(s string) string {
+	b, _ := json.Marshal(s) // escapes <, > and & too
+	return string(b)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/internal/store"
+)
+
+// itemsCommand returns the items command.
+func (app *application) itemsCommand() *command {
+	var (
+		output string
+		yes    bool
+	)
+
+	return &command{
+		name:    "items",
+		summary: "Imports, exports and replays items.",
+		commands: []*command{
+			{
+				name:    "export",
+				summary: "Writes every item as a line of JSON, in creation order.",
+				flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&output, "o", "", "Write to this file instead of stdout")
+				},
+				run: func(args []string) error {
+					w := io.Writer(os.Stdout)
+
+					if output != "" {
+						f, err := os.Create(output)
+						if err != nil {
+							return err
+						}
+						defer f.Close()
+						w = f
+					}
+
+					return app.itemsExport(w)
+				},
+			},
+			{
+				name:    "import",
+				args:    "[file]",
+				summary: "Creates an item for every line of JSON read from file, or stdin, such as written by export. Items get new IDs, only their names are imported, and nothing is imported unless every item is valid.",
+				run: func(args []string) error {
+					r := io.Reader(os.Stdin)
+
+					if len(args) > 0 {
+						f, err := os.Open(args[0])
+						if err != nil {
+							return err
+						}
+						defer f.Close()
+						r = f
+					}
+
+					return app.itemsImport(r)
+				},
+			},
+			{
+				name:    "replay",
+				summary: "Rebuilds the Postgres items table, item history and cached items from the whole event log of event-sourced items, replacing what is there. Servers running with -items-event-sourced should be stopped first.",
+				flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&yes, "yes", false, "Replay without asking for confirmation")
+				},
+				run: func(args []string) error {
+					if !yes && !confirm(os.Stdin, os.Stdout, "This replaces every item and all item history in the database. Continue?") {
+						return errors.New("items replay cancelled")
+					}
+
+					return app.itemsReplay()
+				},
+			},
+		},
+	}
+}
+
+// withItems opens the item store and runs fn with it.
+func (app *application) withItems(fn func(ctx context.Context) error) error {
+	ctx := context.Background()
+
+	shutdown, err := app.natsConnect()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	if err = app.startCache(ctx); err != nil {
+		return err
+	}
+
+	if err = app.openDB(ctx); err != nil {
+		return err
+	}
+	defer app.db.Close()
+
+	js, err := jetstream.New(app.natsClient)
+	if err != nil {
+		return err
+	}
+
+	if err = app.openItems(ctx, js); err != nil {
+		return err
+	}
+
+	return fn(ctx)
+}
+
+// itemsExport writes every item to w as JSON lines.
+func (app *application) itemsExport(w io.Writer) error {
+	return app.withItems(func(ctx context.Context) error {
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+
+		var (
+			cursor   string
+			exported int
+		)
+
+		for {
+			items, next, err := app.items.List(ctx, store.ListParams{Cursor: cursor, Limit: 100})
+			if err != nil {
+				return err
+			}
+
+			for _, item := range items {
+				if err = enc.Encode(item); err != nil {
+					return err
+				}
+			}
+			exported += len(items)
+
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+
+		app.logger.Info("exported items", slog.Int("exported", exported))
+
+		return nil
+	})
+}
+
+// itemsImport creates an item for every JSON line read from r, after checking they are all valid.
+func (app *application) itemsImport(r io.Reader) error {
+	var items []*store.Item
+
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var item store.Item
+		if err := dec.Decode(&item); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("item %d: %w", line, err)
+		}
+
+		if v := item.Validate(); !v.Valid() {
+			return fmt.Errorf("item %d: %w", line, &store.ValidationError{Fields: v.Errors})
+		}
+
+		items = append(items, &store.Item{Name: item.Name})
+	}
+
+	return app.withItems(func(ctx context.Context) error {
+		for i, item := range items {
+			if err := app.items.Create(ctx, item); err != nil {
+				return fmt.Errorf("item %d: %w, %d items imported", i+1, err, i)
+			}
+		}
+
+		app.logger.Info("imported items", slog.Int("imported", len(items)))
+
+		return nil
+	})
+}
+
+// itemsReplay rebuilds the Postgres and cache projections of event-sourced items from the whole event log.
+func (app *application) itemsReplay() error {
+	ctx := context.Background()
+
+	shutdown, err := app.natsConnect()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	if err = app.startCache(ctx); err != nil {
+		return err
+	}
+
+	if err = app.openDB(ctx); err != nil {
+		return err
+	}
+	defer app.db.Close()
+
+	js, err := jetstream.New(app.natsClient)
+	if err != nil {
+		return err
+	}
+
+	// Ensures the event stream exists, so replaying an empty log simply clears the projections
+	if _, err = store.NewEventItemStore(ctx, js, nil); err != nil {
+		return err
+	}
+
+	app.logger.Info("replaying item events")
+
+	applied, err := store.NewProjector(app.db, js, app.cache, app.logger).Rebuild(ctx)
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("replayed item events", slog.Int("applied", applied))
+
+	return nil
+}
+
+// confirm asks a yes or no question on w, reporting whether the answer read from r is yes.
+func confirm(r io.Reader, w io.Writer, question string) bool {
+	_, _ = fmt.Fprintf(w, "%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
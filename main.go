@@ -1,26 +1,44 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"log/slog"
 	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/internal/natsstore"
+	"exampleapp/internal/secret"
+	"exampleapp/internal/store"
 )
 
 var Version = "0.0.1"
 
+// sessionUserKey is the session key holding the authenticated user's ID, used to index sessions by user.
+const sessionUserKey = "userID"
+
 type application struct {
-	config config
-
-	sessions   *scs.SessionManager
-	logger     *slog.Logger
-	natsClient *nats.Conn
-	ready      bool
-	cache      jetstream.KeyValue
-	db         *pgxpool.Pool
+	config   config
+	args     []string   // config flags the config was loaded from, reloaded with them
+	reloadMu sync.Mutex // serializes config reloads
+
+	sessions     atomic.Pointer[scs.SessionManager] // replaced when the session TTL is reloaded, see sessionsFor
+	sessionStore *natsstore.NatsStore
+	logger       *slog.Logger
+	logLevel     *slog.LevelVar
+	redactor     *secret.Redactor
+	natsClient   *nats.Conn
+	ready        bool
+	cache        jetstream.KeyValue
+	db           *pgxpool.Pool
+	items        store.Items
 }
 
 func main() {
@@ -28,16 +46,53 @@ func main() {
 	// gob.Register(time.Time{})
 
 	app := &application{
-		logger: slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		args:     os.Args[1:],
+		logger:   slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		logLevel: new(slog.LevelVar),
 	}
 
-	if err := app.setDefaults(); err != nil {
+	// An invalid config only fails the commands that need it, help and version still work
+	cfg, args, configErr := loadConfig(app.args)
+	switch {
+	case errors.Is(configErr, flag.ErrHelp):
+		if err := app.commands().help(os.Stdout, "exampleapp"); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case configErr != nil && !isConfigError(configErr):
+		app.logger.Error(configErr.Error())
 		os.Exit(1)
 	}
-	app.parseFlags()
+	app.config = cfg
+	app.args = app.args[:len(app.args)-len(args)]
 
-	if err := app.serve(); err != nil {
+	// Now the secrets are known, make sure they never reach the logs
+	app.logLevel.Set(cfg.logLevel())
+	app.redactor = secret.NewRedactor(cfg.secrets()...)
+	app.logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       app.logLevel,
+		ReplaceAttr: app.redactor.ReplaceAttr,
+	}))
+
+	if err := app.commands().execute(app, args, configErr); err != nil {
 		app.logger.Error(err.Error())
 		os.Exit(1)
 	}
 }
+
+// reconfigure reloads the config with extra config flags, given after the command, following those given before it.
+func (app *application) reconfigure(extra []string) error {
+	args := append(slices.Clip(app.args), extra...)
+
+	cfg, _, err := loadConfig(args)
+	if err != nil && !isConfigError(err) {
+		return err
+	}
+
+	app.args = args
+	app.config = cfg
+	app.logLevel.Set(cfg.logLevel())
+	app.redactor.Add(cfg.secrets()...)
+
+	return err
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"exampleapp/db"
+	"exampleapp/internal/migrate"
+)
+
+// migrator returns a Migrator for the embedded migrations, the database must already be open.
+func (app *application) migrator() (*migrate.Migrator, error) {
+	migrations, err := fs.Sub(db.Migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.New(app.db, migrations, app.logger)
+}
+
+// migrateUp applies any pending migrations on startup.
+func (app *application) migrateUp(ctx context.Context) error {
+	m, err := app.migrator()
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("database migrations up to date", slog.Int("applied", applied))
+
+	return nil
+}
+
+// migrateCommand returns the migrate command.
+func (app *application) migrateCommand() *command {
+	return &command{
+		name:    "migrate",
+		summary: "Migrates the database schema with the embedded migrations.",
+		commands: []*command{
+			{
+				name:    "up",
+				summary: "Applies every pending migration.",
+				run: func(args []string) error {
+					return app.withMigrator(func(ctx context.Context, m *migrate.Migrator) error {
+						applied, err := m.Up(ctx)
+						if err != nil {
+							return err
+						}
+
+						app.logger.Info("database migrations up to date", slog.Int("applied", applied))
+
+						return nil
+					})
+				},
+			},
+			{
+				name:    "down",
+				args:    "[n]",
+				summary: "Reverts the last n applied migrations, one by default.",
+				run: func(args []string) error {
+					steps := 1
+					if len(args) > 0 {
+						var err error
+						if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+							return fmt.Errorf("invalid number of steps %q", args[0])
+						}
+					}
+
+					return app.withMigrator(func(ctx context.Context, m *migrate.Migrator) error {
+						reverted, err := m.Down(ctx, steps)
+						if err != nil {
+							return err
+						}
+
+						app.logger.Info("reverted database migrations", slog.Int("reverted", reverted))
+
+						return nil
+					})
+				},
+			},
+			{
+				name:    "status",
+				summary: "Lists the migrations and whether each is applied.",
+				run: func(args []string) error {
+					return app.withMigrator(func(ctx context.Context, m *migrate.Migrator) error {
+						statuses, err := m.Status(ctx)
+						if err != nil {
+							return err
+						}
+
+						tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+						_, _ = fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+						for _, status := range statuses {
+							_, _ = fmt.Fprintf(tw, "%d\t%s\t%t\n", status.Version, status.Name, status.Applied)
+						}
+						return tw.Flush()
+					})
+				},
+			},
+			{
+				name:    "version",
+				summary: "Prints the current schema version, marked dirty if a migration failed part way.",
+				run: func(args []string) error {
+					return app.withMigrator(func(ctx context.Context, m *migrate.Migrator) error {
+						version, dirty, err := m.Version(ctx)
+						if err != nil {
+							return err
+						}
+
+						if dirty {
+							fmt.Printf("%d (dirty)\n", version)
+						} else {
+							fmt.Println(version)
+						}
+
+						return nil
+					})
+				},
+			},
+			{
+				name:    "force",
+				args:    "<version>",
+				summary: "Sets the schema version without running migrations and clears the dirty flag, after a failed migration has been fixed by hand.",
+				run: func(args []string) error {
+					if len(args) == 0 {
+						return errors.New("usage: exampleapp migrate force <version>")
+					}
+
+					version, err := strconv.ParseUint(args[0], 10, 64)
+					if err != nil {
+						return fmt.Errorf("invalid version %q", args[0])
+					}
+
+					return app.withMigrator(func(ctx context.Context, m *migrate.Migrator) error {
+						return m.Force(ctx, version)
+					})
+				},
+			},
+		},
+	}
+}
+
+// withMigrator opens the database and runs fn with a Migrator for it.
+func (app *application) withMigrator(fn func(ctx context.Context, m *migrate.Migrator) error) error {
+	ctx := context.Background()
+
+	if err := app.openDB(ctx); err != nil {
+		return err
+	}
+	defer app.db.Close()
+
+	m, err := app.migrator()
+	if err != nil {
+		return err
+	}
+
+	return fn(ctx, m)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/internal/kvbucket"
+)
+
+// reloaders apply a changed setting to the running server, keyed by setting. Settings without one take effect on the
+// next restart.
+func (app *application) reloaders() map[string]func(ctx context.Context, cfg *config) error {
+	return map[string]func(ctx context.Context, cfg *config) error{
+		"log.level":    app.reloadLogLevel,
+		"sessions.ttl": app.reloadSessionTTL,
+		"cache.ttl":    app.reloadCacheTTL,
+	}
+}
+
+// reload re-reads the config file and environment, applies the changed settings that are safe to change at runtime
+// and logs those that need a restart. An invalid config is rejected whole, leaving the running config as it was.
+func (app *application) reload(ctx context.Context) (applied, restart []string, err error) {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	cfg, _, err := loadConfig(app.args)
+	if err != nil {
+		app.logger.Error("unable to reload config", slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+
+	// Redact new secrets before anything can log them
+	app.redactor.Add(cfg.secrets()...)
+
+	reloaders := app.reloaders()
+	applied, restart = []string{}, []string{}
+
+	next := cfg.settings()
+	for i, s := range app.config.settings() {
+		if s.get() == next[i].get() {
+			continue
+		}
+
+		reload, ok := reloaders[s.name]
+		if !ok {
+			app.logger.Warn("setting changed, restart to apply", slog.String("setting", s.name))
+			restart = append(restart, s.name)
+			continue
+		}
+
+		if err = reload(ctx, &cfg); err != nil {
+			app.logger.Error("unable to apply setting", slog.String("setting", s.name), slog.String("error", err.Error()))
+			restart = append(restart, s.name)
+			continue
+		}
+
+		s.set(next[i])
+		app.config.sources[s.name] = cfg.sources[s.name]
+		applied = append(applied, s.name)
+	}
+
+	app.logger.Info("reloaded config", slog.Any("applied", applied), slog.Any("restart", restart))
+
+	return applied, restart, nil
+}
+
+// reloadOnHangup reloads the config whenever the process receives SIGHUP.
+func (app *application) reloadOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for s := range hangup {
+		app.logger.Info("reloading config", slog.String("signal", s.String()))
+		_, _, _ = app.reload(ctx) // logged
+	}
+}
+
+func (app *application) reloadLogLevel(_ context.Context, cfg *config) error {
+	app.logLevel.Set(cfg.logLevel())
+	return nil
+}
+
+// reloadSessionTTL updates the session buckets' TTLs, the upper bound on any session's lifetime, and swaps in a
+// session manager issuing sessions with the new TTL. Existing sessions keep their expiry within the bucket TTL.
+func (app *application) reloadSessionTTL(ctx context.Context, cfg *config) error {
+	js, err := jetstream.New(app.natsClient)
+	if err != nil {
+		return err
+	}
+
+	// Only the TTL, bucket names need a restart
+	sessionsBucket, usersBucket := app.config.sessionBuckets()
+	sessionsBucket.TTL, usersBucket.TTL = cfg.sessions.TTL, cfg.sessions.TTL
+
+	for _, bucket := range []jetstream.KeyValueConfig{sessionsBucket, usersBucket} {
+		if _, err = kvbucket.Ensure(ctx, js, bucket, app.logger); err != nil {
+			return err
+		}
+	}
+
+	app.sessions.Store(app.newSessionManager(cfg.sessions.TTL))
+
+	return nil
+}
+
+// reloadCacheTTL updates the cache bucket's TTL, which JetStream applies to the entries already cached too.
+func (app *application) reloadCacheTTL(ctx context.Context, cfg *config) error {
+	js, err := jetstream.New(app.natsClient)
+	if err != nil {
+		return err
+	}
+
+	// Only the TTL, the bucket name needs a restart
+	bucket := app.config.cacheBucket()
+	bucket.TTL = cfg.cache.TTL
+
+	_, err = kvbucket.Ensure(ctx, js, bucket, app.logger)
+	return err
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
 
+	"github.com/alexedwards/scs/v2"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
 	"exampleapp/internal/handlers"
+	"exampleapp/internal/natsstore"
+	"exampleapp/internal/store"
 )
 
 func (app *application) routes() http.Handler {
@@ -16,13 +22,87 @@ func (app *application) routes() http.Handler {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Route("/", func(r chi.Router) {
-		r.Use(app.sessions.LoadAndSave) // Session middleware
+		r.Use(natsstore.TrackRevisions) // Must wrap session middleware
+		r.Use(app.loadAndSave)          // Session middleware
+		r.Use(app.actor)                // Attributes item changes to the signed in user
+		r.Use(app.endOnRevoke)          // Closes SSE streams of revoked sessions
 		r.Get("/", handlers.Root("landing-page"))
 		r.Get("/landing-page", handlers.LandingPage())
+		r.Route("/items", func(r chi.Router) {
+			r.Get("/", handlers.Items(app.items))
+			r.Get("/{id}", handlers.Item(app.items))
+			r.Get("/{id}/history", handlers.ItemHistory(app.items))
+			r.Put("/{id}", handlers.UpdateItem(app.items))
+			r.Delete("/{id}", handlers.DeleteItem(app.items))
+		})
 		r.Route("/user", func(r chi.Router) {
 			r.Get("/profile", handlers.UserProfile())
 		})
 	})
 	r.Get("/healthz", handlers.Health(app.ready))
+	if app.config.admin.token != "" {
+		r.Post("/admin/reload", handlers.Reload(app.config.admin.token, app.reload))
+	}
 	return r
 }
+
+// actor attributes item changes made while handling the request to the signed in user, if any, see store.WithActor.
+func (app *application) actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID := sessionsFor(r).GetString(r.Context(), sessionUserKey); userID != "" {
+			r = r.WithContext(store.WithActor(r.Context(), userID))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// endOnRevoke cancels the context of Datastar requests, whose SSE streams can stay open, when their session is revoked
+// by natsstore.RevokeUser, so signing a user out everywhere also closes their open streams.
+func (app *application) endOnRevoke(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := sessionsFor(r).Token(r.Context())
+		if r.Header.Get("Datastar-Request") != "true" || token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		revoked, err := app.sessionStore.Revoked(ctx, token)
+		if err != nil {
+			app.logger.Error("unable to watch for session revocation", slog.String("error", err.Error()))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		go func() {
+			select {
+			case <-revoked:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type sessionsKey struct{}
+
+// loadAndSave loads and saves the request's session with the current session manager. The manager is replaced when
+// the session TTL is reloaded, so handlers must use the one the session was loaded with, from sessionsFor.
+func (app *application) loadAndSave(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessions := app.sessions.Load()
+		r = r.WithContext(context.WithValue(r.Context(), sessionsKey{}, sessions))
+
+		sessions.LoadAndSave(next).ServeHTTP(w, r)
+	})
+}
+
+// sessionsFor returns the session manager the request's session was loaded with.
+func sessionsFor(r *http.Request) *scs.SessionManager {
+	return r.Context().Value(sessionsKey{}).(*scs.SessionManager)
+}
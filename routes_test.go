@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"exampleapp/internal/natsstore"
+	"exampleapp/internal/natstest"
+)
+
+// TestEndOnRevoke checks revoking a user's sessions ends their open Datastar requests.
+func TestEndOnRevoke(t *testing.T) {
+	ctx := context.Background()
+	js := natstest.JetStream(t)
+
+	users, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "session-users"})
+	if err != nil {
+		t.Fatalf("CreateKeyValue: %v", err)
+	}
+
+	app := &application{logger: slog.New(slog.DiscardHandler)}
+	app.sessionStore, err = natsstore.New(ctx, js, jetstream.KeyValueConfig{Bucket: "sessions"},
+		natsstore.WithLogger(app.logger),
+		natsstore.WithUserIndex(users, natsstore.GobUserID(sessionUserKey)),
+	)
+	if err != nil {
+		t.Fatalf("natsstore.New: %v", err)
+	}
+	app.sessions.Store(app.newSessionManager(time.Hour))
+
+	signIn := app.loadAndSave(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		sessionsFor(r).Put(r.Context(), sessionUserKey, "alice@example.com")
+	}))
+
+	rec := httptest.NewRecorder()
+	signIn.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("no session cookie set")
+	}
+
+	started := make(chan struct{})
+	stream := app.loadAndSave(app.endOnRevoke(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("Datastar-Request", "true")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stream.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("stream not started")
+	}
+
+	revoked, err := app.sessionStore.RevokeUser(ctx, "alice@example.com")
+	if err != nil || revoked != 1 {
+		t.Fatalf("RevokeUser: got %d, %v, want 1 revoked", revoked, err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream not ended by revocation")
+	}
+}
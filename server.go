@@ -2,21 +2,32 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 
+	"exampleapp/internal/kvbucket"
 	"exampleapp/internal/natsstore"
+	"exampleapp/internal/store"
+)
+
+const (
+	dbConnectAttempts = 5
+	dbConnectBackoff  = 500 * time.Millisecond
 )
 
 func (app *application) serve() error {
@@ -35,8 +46,23 @@ func (app *application) serve() error {
 		return err
 	}
 
+	if err = app.openDB(ctx); err != nil {
+		return err
+	}
+
+	if app.config.database.migrateOnStart {
+		if err = app.migrateUp(ctx); err != nil {
+			return err
+		}
+	}
+
+	stopItems, err := app.startItems(ctx)
+	if err != nil {
+		return err
+	}
+
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", 8080),
+		Addr:         net.JoinHostPort(app.config.http.address, strconv.Itoa(app.config.http.port)),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
@@ -44,6 +70,8 @@ func (app *application) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
+	go app.reloadOnHangup(ctx)
+
 	shutdownError := make(chan error)
 
 	go func() {
@@ -56,6 +84,16 @@ func (app *application) serve() error {
 		ctxCancel, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
+		// Drain in-flight requests first, they still need the database and NATS
+		app.logger.Info("shutting down http server")
+		err := srv.Shutdown(ctxCancel)
+
+		app.logger.Info("stopping item workers")
+		stopItems()
+
+		app.logger.Info("closing database pool")
+		app.db.Close()
+
 		app.logger.Info("closing NATS client connection")
 		app.natsClient.Close()
 
@@ -64,8 +102,7 @@ func (app *application) serve() error {
 		natsSrv.Shutdown()
 		app.logger.Info("stopped embedded NATS server")
 
-		app.logger.Info("shutting down http server")
-		shutdownError <- srv.Shutdown(ctxCancel)
+		shutdownError <- err
 	}()
 
 	app.logger.Info("starting http server", slog.String("addr", srv.Addr))
@@ -124,42 +161,190 @@ func (app *application) natsServe() (*server.Server, error) {
 	return srv, nil
 }
 
+// openDB opens the Postgres pool and pings it, retrying with exponential backoff while the database comes up.
+func (app *application) openDB(ctx context.Context) error {
+	db, err := pgxpool.New(ctx, app.config.databaseDSN())
+	if err != nil {
+		return err
+	}
+
+	backoff := dbConnectBackoff
+
+	for attempt := 1; ; attempt++ {
+		if err = db.Ping(ctx); err == nil {
+			break
+		}
+
+		if attempt == dbConnectAttempts {
+			db.Close()
+			return fmt.Errorf("unable to reach database after %d attempts: %w", attempt, err)
+		}
+
+		app.logger.Warn(
+			"unable to reach database, retrying",
+			slog.Int("attempt", attempt),
+			slog.Duration("backoff", backoff),
+			slog.String("error", err.Error()),
+		)
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	app.logger.Info(
+		"connected to database",
+		slog.String("host", app.config.database.host),
+		slog.Int("port", app.config.database.port),
+		slog.String("name", app.config.database.name),
+	)
+
+	app.db = db
+
+	return nil
+}
+
+// startItems sets up the item store and starts its background worker, returning a function that stops the worker and
+// waits for it to finish. Postgres backed items are propagated to JetStream and the cache by the outbox relay, while
+// event-sourced items are projected from the event log into Postgres and the cache.
+func (app *application) startItems(ctx context.Context) (func(), error) {
+	js, err := jetstream.New(app.natsClient)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = app.openItems(ctx, js); err != nil {
+		return nil, err
+	}
+
+	var run func(ctx context.Context)
+
+	if app.config.items.eventSourced {
+		projector := store.NewProjector(app.db, js, app.cache, app.logger)
+		run = func(ctx context.Context) {
+			if err := projector.Run(ctx); err != nil {
+				app.logger.Error("item projector stopped", slog.String("error", err.Error()))
+			}
+		}
+	} else {
+		relay, err := store.NewRelay(ctx, app.db, js, app.cache, app.logger)
+		if err != nil {
+			return nil, err
+		}
+		run = relay.Run
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}, nil
+}
+
+// openItems sets up the configured item store without the background worker startItems runs for it, so changes made
+// outside the server are propagated once it is running.
+func (app *application) openItems(ctx context.Context, js jetstream.JetStream) error {
+	projection := store.NewItemStore(app.db, app.cache, store.WithLogger(app.logger))
+
+	if !app.config.items.eventSourced {
+		app.items = projection
+		return nil
+	}
+
+	events, err := store.NewEventItemStore(ctx, js, projection)
+	if err != nil {
+		return err
+	}
+	app.items = events
+
+	return nil
+}
+
 func (app *application) startSessions(ctx context.Context) error {
 	js, err := jetstream.New(app.natsClient)
 	if err != nil {
 		return err
 	}
 
-	sessionStore := natsstore.Must(
-		natsstore.New(
-			ctx,
-			js,
-			jetstream.KeyValueConfig{
-				Bucket:      app.config.sessions.bucketName,
-				Compression: true,
-				TTL:         app.config.sessions.TTL,
-			},
-			natsstore.WithPrefix(app.config.sessions.prefix),
-		),
-	)
+	opts := []natsstore.Option{
+		natsstore.WithPrefix(app.config.sessions.prefix),
+		natsstore.WithLogger(app.logger),
+	}
+
+	if app.config.sessions.optimisticConcurrency {
+		opts = append(opts, natsstore.WithOptimisticConcurrency(natsstore.GobMerge)) // requires natsstore.TrackRevisions in routes
+	}
+
+	if app.config.sessions.keys != "" {
+		keyring, err := parseKeyring(app.config.sessions.keys.Reveal())
+		if err != nil {
+			return err
+		}
+		opts = append(opts, natsstore.WithEncryption(keyring))
+	}
+
+	sessionsBucket, usersBucket := app.config.sessionBuckets()
 
-	app.sessions = scs.New()
-	app.sessions.Store = sessionStore
+	// Index sessions by the user ID handlers store under sessionUserKey so all of a user's sessions can be revoked
+	users, err := kvbucket.Ensure(ctx, js, usersBucket, app.logger)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, natsstore.WithUserIndex(users, natsstore.GobUserID(sessionUserKey)))
+
+	app.sessionStore = natsstore.Must(natsstore.New(ctx, js, sessionsBucket, opts...))
+	app.sessions.Store(app.newSessionManager(app.config.sessions.TTL))
 
 	return nil
 }
 
+// newSessionManager returns a session manager over the session store, issuing sessions that last ttl.
+func (app *application) newSessionManager(ttl time.Duration) *scs.SessionManager {
+	sessions := scs.New()
+	sessions.Store = app.sessionStore
+	sessions.Lifetime = ttl
+
+	return sessions
+}
+
+// parseKeyring parses comma separated id:base64key pairs into a session keyring, the first pair being the primary key.
+func parseKeyring(value string) (*natsstore.Keyring, error) {
+	var primary string
+	keys := make(map[string][]byte)
+
+	for pair := range strings.SplitSeq(value, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: expected id:base64key", natsstore.ErrInvalidKeyring)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %q: %v", natsstore.ErrInvalidKeyring, id, err)
+		}
+
+		if primary == "" {
+			primary = id
+		}
+		keys[id] = key
+	}
+
+	return natsstore.NewKeyring(primary, keys)
+}
+
 func (app *application) startCache(ctx context.Context) error {
 	js, err := jetstream.New(app.natsClient)
 	if err != nil {
 		return err
 	}
 
-	cacheStore, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
-		Bucket:      app.config.cache.bucketName,
-		Compression: true,
-		TTL:         app.config.cache.TTL,
-	})
+	cacheStore, err := kvbucket.Ensure(ctx, js, app.config.cacheBucket(), app.logger)
 	if err != nil {
 		return err
 	}
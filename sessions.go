@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexedwards/scs/pgxstore"
+	"github.com/alexedwards/scs/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+
+	"exampleapp/internal/natsstore"
+	"exampleapp/internal/sessionmigrate"
+)
+
+// sessionStore is a session store sessions can be copied from and to.
+type sessionStore interface {
+	scs.Store
+	scs.IterableStore
+}
+
+// sessionsCommand returns the sessions command.
+func (app *application) sessionsCommand() *command {
+	var (
+		from, to, dsn string
+		dryRun        bool
+		every         int
+		userID        string
+	)
+
+	return &command{
+		name:    "sessions",
+		summary: "Manages the sessions in the NATS session bucket.",
+		commands: []*command{
+			{
+				name:    "list",
+				summary: "Lists the active sessions with their user and expiry.",
+				run: func(args []string) error {
+					return app.sessionsList()
+				},
+			},
+			{
+				name:    "revoke",
+				args:    "[token ...]",
+				summary: "Revokes the sessions with the given tokens, or every session of the user given with -user.",
+				flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&userID, "user", "", "Revoke every session of this user ID")
+				},
+				run: func(args []string) error {
+					if (userID == "") == (len(args) == 0) {
+						return errors.New("usage: exampleapp sessions revoke -user <id> | <token> ...")
+					}
+
+					return app.sessionsRevoke(userID, args)
+				},
+			},
+			{
+				name:    "copy",
+				summary: "Copies sessions, with their remaining lifetime, between the NATS session bucket and the Postgres sessions table of the scs pgxstore.",
+				flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&from, "from", "postgres", "Source session store (nats or postgres)")
+					fs.StringVar(&to, "to", "nats", "Destination session store (nats or postgres)")
+					fs.StringVar(&dsn, "dsn", "", "Postgres connection string for the postgres store, defaults to the configured database")
+					fs.BoolVar(&dryRun, "dry-run", false, "Read and decode sessions without writing them")
+					fs.IntVar(&every, "progress", 1000, "Report progress every n sessions")
+				},
+				run: func(args []string) error {
+					if dsn == "" {
+						dsn = app.config.databaseDSN()
+					}
+
+					return app.sessionsCopy(from, to, dsn, dryRun, every)
+				},
+			},
+		},
+	}
+}
+
+// sessionsList prints the active sessions, soonest to expire first.
+func (app *application) sessionsList() error {
+	ctx := context.Background()
+
+	shutdown, err := app.natsConnect()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	if err = app.startSessions(ctx); err != nil {
+		return err
+	}
+
+	sessions, err := app.sessionStore.AllCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		token, userID string
+		expiry        time.Time
+	}
+
+	rows := make([]row, 0, len(sessions))
+	userID := natsstore.GobUserID(sessionUserKey)
+
+	for token, b := range sessions {
+		expiry, _, err := scs.GobCodec{}.Decode(b)
+		if err != nil {
+			return fmt.Errorf("session %s: %w", token, err)
+		}
+
+		id, _ := userID(b)
+		rows = append(rows, row{token, id, expiry})
+	}
+
+	slices.SortFunc(rows, func(a, b row) int { return a.expiry.Compare(b.expiry) })
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "TOKEN\tUSER\tEXPIRES")
+	for _, r := range rows {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", r.token, r.userID, r.expiry.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// sessionsRevoke deletes every session of userID, if given, or else the sessions with the given tokens.
+func (app *application) sessionsRevoke(userID string, tokens []string) error {
+	ctx := context.Background()
+
+	shutdown, err := app.natsConnect()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	if err = app.startSessions(ctx); err != nil {
+		return err
+	}
+
+	if userID != "" {
+		revoked, err := app.sessionStore.RevokeUser(ctx, userID)
+		app.logger.Info("revoked sessions", slog.String("user", userID), slog.Int("revoked", revoked))
+
+		return err
+	}
+
+	for _, token := range tokens {
+		if err = app.sessionStore.DeleteCtx(ctx, token); err != nil {
+			return err
+		}
+	}
+
+	app.logger.Info("revoked sessions", slog.Int("revoked", len(tokens)))
+
+	return nil
+}
+
+// sessionsCopy copies sessions, with their remaining lifetime, between the NATS session bucket and the Postgres
+// sessions table of the scs pgxstore.
+func (app *application) sessionsCopy(from, to, dsn string, dryRun bool, every int) error {
+	if from == to {
+		return fmt.Errorf("source and destination are both %s", from)
+	}
+
+	ctx := context.Background()
+
+	stores := make(map[string]sessionStore, 2)
+
+	for _, name := range []string{from, to} {
+		switch name {
+		case "nats":
+			shutdown, err := app.natsConnect()
+			if err != nil {
+				return err
+			}
+			defer shutdown()
+
+			if err = app.startSessions(ctx); err != nil {
+				return err
+			}
+			stores[name] = app.sessionStore
+		case "postgres":
+			db, err := pgxpool.New(ctx, dsn)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			// No cleanup, the store only lives as long as the copy
+			stores[name] = pgxstore.NewWithCleanupInterval(db, 0)
+		default:
+			return fmt.Errorf("unknown session store %q", name)
+		}
+	}
+
+	app.logger.Info(
+		"copying sessions",
+		slog.String("from", from),
+		slog.String("to", to),
+		slog.Bool("dry-run", dryRun),
+	)
+
+	opts := sessionmigrate.Options{DryRun: dryRun}
+	if every > 0 {
+		opts.ProgressEvery = every
+		opts.Progress = func(report sessionmigrate.Report) {
+			app.logger.Info("copy progress", slog.Int("processed", report.Total), slog.Int("copied", report.Copied))
+		}
+	}
+
+	report, err := sessionmigrate.Copy(ctx, stores[from], stores[to], opts)
+
+	app.logger.Info(
+		"copied sessions",
+		slog.Int("total", report.Total),
+		slog.Int("copied", report.Copied),
+		slog.Int("expired", report.Expired),
+		slog.Int("failed", report.Failed),
+		slog.Bool("dry-run", dryRun),
+	)
+
+	return err
+}
+
+// natsConnect connects to an already running NATS server at the configured address, or starts the embedded server
+// when there isn't one, returning a function that closes the connection and any server it started.
+func (app *application) natsConnect() (func(), error) {
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%d", app.config.nats.address, app.config.nats.port))
+	if err == nil {
+		app.natsClient = nc
+		return nc.Close, nil
+	}
+
+	natsSrv, err := app.natsServe()
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		app.natsClient.Close()
+		natsSrv.Shutdown()
+	}, nil
+}